@@ -0,0 +1,269 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+A software Ethernet bridge, that switches frames between several ports, such as
+TAP devices (tap.Interface) or internal hosts (icmp.Host).
+
+The bridge is VLAN-aware (a C-VLAN bridge as of IEEE 802.1Q): Every frame is
+classified into a VLAN on ingress and only forwarded to ports, that are members
+of that VLAN. Frames carrying an S-Tag (802.1ad) are not bridged. Frames to the
+reserved group addresses 01-80-C2-00-00-00 to 0F (STP, LLDP, ...) are never
+forwarded to external ports, only delivered to internal hosts.
+*/
+package bridge
+
+import "github.com/maxymania/ipsolution/eth"
+import "github.com/google/gopacket"
+
+import "sync"
+import "time"
+import "fmt"
+
+var EExists = fmt.Errorf("Port exists")
+
+/*
+ * A Link is the medium of a port. tap.Interface implements it.
+ * WritePacketData must not pass frames back to the bridge synchronously.
+ */
+type Link interface{
+	WritePacketData(data []byte) error
+}
+
+/*
+ * A Link, the bridge reads frames from, using Bridge.Run.
+ */
+type SourceLink interface{
+	Link
+	ReadPacketData() (data []byte, ci gopacket.CaptureInfo, err error)
+}
+
+type PortMode uint8
+const (
+	/* Untagged (and priority-tagged) frames belong to PVID, tagged frames are dropped. */
+	ACCESS PortMode = iota
+	/*
+	 * Tagged frames are accepted for all allowed VLANs. Untagged frames belong to PVID
+	 * (the native VLAN) and are dropped, if PVID is 0.
+	 */
+	TRUNK
+)
+func (p PortMode) String() string {
+	switch p {
+	case ACCESS: return "access"
+	case TRUNK: return "trunk"
+	}
+	return fmt.Sprintf("PortMode(%d)",uint8(p))
+}
+
+/*
+ * A bridge port. The fields may be changed while the bridge is running, if the
+ * Bridge's Lock is held.
+ */
+type Port struct{
+	Name string
+	Link Link
+	Mode PortMode
+	PVID uint16
+	
+	/* VLANs allowed on a TRUNK port, nil allows all. */
+	Allowed map[uint16]bool
+	
+	bridge *Bridge
+}
+
+/* Reports, whether the port is a member of the VLAN vid. */
+func (p *Port) Member(vid uint16) bool {
+	if vid==0 { return false }
+	if p.PVID==vid { return true }
+	if p.Mode!=TRUNK { return false }
+	if p.Allowed==nil { return true }
+	return p.Allowed[vid]
+}
+
+/* Returns the VLAN of a received frame, or 0, if the frame is to be dropped. */
+func (p *Port) classify(e *eth.EthLayer2) uint16 {
+	if e.SVLANIdentifier!=0 || len(e.Tags)>1 { return 0 }
+	if e.VLANIdentifier==0 { return p.PVID }
+	if p.Mode!=TRUNK || !p.Member(e.VLANIdentifier) { return 0 }
+	return e.VLANIdentifier
+}
+
+/* Frames of the native VLAN and on access ports are sent untagged. */
+func (p *Port) tagged(vid uint16) bool {
+	return p.Mode==TRUNK && vid!=p.PVID
+}
+
+type Bridge struct{
+	/* Entries of the learning table expire after AgingTime (default: 300 seconds). */
+	AgingTime time.Duration
+	
+	ports []*Port
+	fdb fdb
+	sync.RWMutex
+}
+func (b *Bridge) Init() *Bridge {
+	b.AgingTime = 300*time.Second
+	b.fdb.init()
+	return b
+}
+
+/* Adds a port. A new port is an ACCESS port in VLAN 1, unless configured otherwise. */
+func (b *Bridge) AddPort(p *Port) error {
+	b.Lock(); defer b.Unlock()
+	if p.bridge!=nil { return EExists }
+	if p.PVID==0 && p.Mode==ACCESS { p.PVID = 1 }
+	p.bridge = b
+	b.ports = append(b.ports,p)
+	return nil
+}
+
+/* Removes a port and flushes it's entries from the learning table. */
+func (b *Bridge) RemovePort(p *Port) {
+	b.Lock()
+	for i,q := range b.ports {
+		if q!=p { continue }
+		b.ports = append(b.ports[:i],b.ports[i+1:]...)
+		p.bridge = nil
+		if hl,ok := p.Link.(*hostLink); ok { close(hl.queue) }
+		break
+	}
+	b.Unlock()
+	b.fdb.flush(p)
+}
+func (b *Bridge) Ports() []*Port {
+	b.RLock(); defer b.RUnlock()
+	return append([]*Port(nil),b.ports...)
+}
+
+/*
+ * Reads frames from the Link of p until an error occurs, and passes them to
+ * Input.
+ */
+func (b *Bridge) Run(p *Port, l SourceLink) error {
+	for {
+		data,_,err := l.ReadPacketData()
+		if err!=nil { return err }
+		b.Input(p,data)
+	}
+}
+
+/*
+ * Switches a frame, received on port p.
+ */
+func (b *Bridge) Input(p *Port, data []byte) {
+	var e eth.EthLayer2
+	if e.DecodeFromBytes(data,gopacket.NilDecodeFeedback)!=nil { return }
+	
+	/*
+	 * The frames are forwarded under the read lock, so that ports are not
+	 * removed meanwhile. Links must therefore not call Input synchronously.
+	 */
+	b.RLock(); defer b.RUnlock()
+	vid := p.classify(&e)
+	if vid==0 || p.bridge!=b { return }
+	
+	/* IEEE 802.1D 7.8: source addresses are learned, unless they are group addresses. */
+	if e.SrcMAC[0]&1==0 {
+		b.fdb.learn(vid,e.SrcMAC,p)
+	}
+	var out []*Port
+	if isReserved(e.DstMAC) {
+		/*
+		 * IEEE 802.1D 7.12.6: Frames to the reserved addresses (such as BPDUs
+		 * and LLDPDUs to the nearest bridge) are not relayed. They are
+		 * delivered to the internal hosts only.
+		 */
+		for _,q := range b.ports {
+			if _,ok := q.Link.(*hostLink); ok && q!=p && q.Member(vid) { out = append(out,q) }
+		}
+		b.forward(&e,data,vid,out)
+		return
+	}
+	if e.DstMAC[0]&1==0 {
+		if q := b.fdb.lookup(vid,e.DstMAC,b.AgingTime); q!=nil {
+			/* Frames to the port, they were received on, are filtered. */
+			if q!=p && q.bridge==b && q.Member(vid) { b.forward(&e,data,vid,[]*Port{q}) }
+			return
+		}
+	}
+	/* Broadcast, multicast and unknown unicast are flooded. */
+	for _,q := range b.ports {
+		if q!=p && q.Member(vid) { out = append(out,q) }
+	}
+	b.forward(&e,data,vid,out)
+}
+
+/* Reports, whether mac is one of the reserved group addresses 01-80-C2-00-00-00 to 0F. */
+func isReserved(mac []byte) bool {
+	return len(mac)==6 && mac[0]==0x01 && mac[1]==0x80 && mac[2]==0xC2 &&
+		mac[3]==0 && mac[4]==0 && mac[5]&0xF0==0
+}
+
+func (b *Bridge) forward(e *eth.EthLayer2, data []byte, vid uint16, out []*Port) {
+	if len(out)==0 { return }
+	var frames [2][]byte
+	for _,q := range out {
+		t := 0
+		if q.tagged(vid) { t = 1 }
+		if frames[t]==nil { frames[t] = egress(e,data,vid,t==1) }
+		if frames[t]!=nil && q.Link!=nil { q.Link.WritePacketData(frames[t]) }
+	}
+}
+
+/*
+ * Builds the frame, as it leaves a port. The received frame is reused, if it
+ * is already tagged as required.
+ */
+func egress(e *eth.EthLayer2, data []byte, vid uint16, tagged bool) []byte {
+	if tagged && e.VLANIdentifier==vid { return data }
+	if !tagged && len(e.Tags)==0 { return data }
+	
+	o := *e
+	o.Tags = nil
+	o.SVLANIdentifier = 0
+	if tagged {
+		o.VLANIdentifier = vid
+	} else {
+		o.VLANIdentifier = 0
+		o.Priority = 0
+		o.DropEligible = false
+	}
+	b := gopacket.NewSerializeBufferExpectedSize(len(data)+4,0)
+	err := gopacket.SerializeLayers(b,gopacket.SerializeOptions{false,false},&o,gopacket.Payload(e.Payload))
+	if err!=nil { return nil }
+	return b.Bytes()
+}
+
+/* Removes expired entries from the learning table. Should be called periodically. */
+func (b *Bridge) TimerEvent(NOW time.Time) {
+	b.RLock(); aging := b.AgingTime; b.RUnlock()
+	b.fdb.expire(NOW,aging)
+}
+
+/* Returns the entries of the learning table. */
+func (b *Bridge) FDB() []FDBEntry {
+	b.RLock(); aging := b.AgingTime; b.RUnlock()
+	return b.fdb.snapshot(aging)
+}
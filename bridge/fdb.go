@@ -0,0 +1,115 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package bridge
+
+import "net"
+import "sync"
+import "time"
+import "fmt"
+
+type fdbKey struct{
+	vid uint16
+	mac [6]byte
+}
+type fdbEntry struct{
+	port *Port
+	seen time.Time
+}
+
+/*
+ * The learning table (filtering database) of a bridge. It maps a VLAN-ID and
+ * a MAC address to the port, the address was last seen on.
+ */
+type fdb struct{
+	table map[fdbKey]*fdbEntry
+	mutex sync.Mutex
+}
+func (f *fdb) init() {
+	f.table = make(map[fdbKey]*fdbEntry)
+}
+func mkFdbKey(vid uint16, mac net.HardwareAddr) (k fdbKey) {
+	k.vid = vid
+	copy(k.mac[:],mac)
+	return
+}
+func (f *fdb) learn(vid uint16, mac net.HardwareAddr, p *Port) {
+	k := mkFdbKey(vid,mac)
+	f.mutex.Lock(); defer f.mutex.Unlock()
+	if fe,ok := f.table[k]; ok {
+		fe.port = p
+		fe.seen = time.Now()
+		return
+	}
+	f.table[k] = &fdbEntry{p,time.Now()}
+}
+func (f *fdb) lookup(vid uint16, mac net.HardwareAddr, aging time.Duration) *Port {
+	k := mkFdbKey(vid,mac)
+	f.mutex.Lock(); defer f.mutex.Unlock()
+	fe,ok := f.table[k]
+	if !ok { return nil }
+	if time.Since(fe.seen)>aging {
+		delete(f.table,k)
+		return nil
+	}
+	return fe.port
+}
+func (f *fdb) flush(p *Port) {
+	f.mutex.Lock(); defer f.mutex.Unlock()
+	for k,fe := range f.table {
+		if fe.port==p { delete(f.table,k) }
+	}
+}
+func (f *fdb) expire(NOW time.Time, aging time.Duration) {
+	f.mutex.Lock(); defer f.mutex.Unlock()
+	for k,fe := range f.table {
+		if NOW.Sub(fe.seen)>aging { delete(f.table,k) }
+	}
+}
+
+/* An entry of the learning table, as returned by Bridge.FDB(). */
+type FDBEntry struct{
+	MAC net.HardwareAddr
+	VLAN uint16
+	Port *Port
+	Age time.Duration
+}
+
+/* Formats the entry like "bridge fdb show". */
+func (fe FDBEntry) String() string {
+	return fmt.Sprintf("%v dev %s vlan %d age %v",fe.MAC,fe.Port.Name,fe.VLAN,fe.Age.Truncate(time.Second))
+}
+
+func (f *fdb) snapshot(aging time.Duration) (r []FDBEntry) {
+	NOW := time.Now()
+	f.mutex.Lock(); defer f.mutex.Unlock()
+	for k,fe := range f.table {
+		age := NOW.Sub(fe.seen)
+		if age>aging { continue }
+		mac := make(net.HardwareAddr,6)
+		copy(mac,k.mac[:])
+		r = append(r,FDBEntry{mac,k.vid,fe.port,age})
+	}
+	return
+}
@@ -0,0 +1,96 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package bridge
+
+import "github.com/maxymania/ipsolution/icmp"
+import "github.com/maxymania/ipsolution/ip"
+import "github.com/maxymania/ipsolution/eth"
+import "github.com/google/gopacket"
+
+/*
+ * Delivers the frames of a bridge port to an internal host. The frames are
+ * queued and passed to the host by a goroutine, as the host may hold locks of
+ * it's neighbor caches while sending, and the reply of another internal host
+ * would otherwise be received synchronously.
+ */
+type hostLink struct{
+	host icmp.FrameInput
+	inject portInput
+	queue chan []byte
+}
+func (h *hostLink) WritePacketData(data []byte) error {
+	select {
+	case h.queue <- append([]byte(nil),data...):
+	default: /* Queue is full, drop the frame. */
+	}
+	return nil
+}
+func (h *hostLink) run() {
+	var e eth.EthLayer2
+	var i ip.IPLayerPart
+	for data := range h.queue {
+		if e.DecodeFromBytes(data,gopacket.NilDecodeFeedback)!=nil { continue }
+		if fh,ok := h.host.(icmp.FrameHandler); ok {
+			fh.InputFrame(&e,h.inject)
+			continue
+		}
+		if i.DecodeType(e.NextLayerType(),e.Payload,gopacket.NilDecodeFeedback)!=nil { continue }
+		h.host.Input(&e,&i,h.inject)
+	}
+}
+
+/*
+ * Frames written to a portInput are received by the bridge on it's port.
+ */
+type portInput struct{
+	bridge *Bridge
+	port *Port
+}
+func (p portInput) WritePacketData(data []byte) error {
+	p.bridge.Input(p.port,data)
+	return nil
+}
+
+/* Number of frames queued for an internal host. */
+const HostQueueLen = 256
+
+/*
+ * Attaches an internal host as a bridge-local port. Frames sent by the host
+ * are switched by the bridge as if received on the returned port. If h is an
+ * *icmp.Host without Output, it's Output is set to the port.
+ *
+ * The port is an ACCESS port in VLAN 1. For a host serving multiple VLANs
+ * (for example an *icmp.VlanMux) the port may be configured as TRUNK.
+ * RemovePort detaches the host.
+ */
+func (b *Bridge) AddHost(name string, h icmp.FrameInput) (*Port,error) {
+	p := &Port{Name:name}
+	hl := &hostLink{h,portInput{b,p},make(chan []byte,HostQueueLen)}
+	p.Link = hl
+	if ih,ok := h.(*icmp.Host); ok && ih.Output==nil { ih.Output = hl.inject }
+	if err := b.AddPort(p); err!=nil { return nil,err }
+	go hl.run()
+	return p,nil
+}
@@ -31,39 +31,208 @@ import "github.com/google/gopacket"
 import "github.com/google/gopacket/layers"
 import "fmt"
 
+/*
+A single VLAN tag. TPID is the EtherType introducing the tag, eighter 0x8100
+(802.1Q C-Tag) or 0x88a8 (802.1ad S-Tag). Priority is the 802.1p PCP and
+DropEligible the DEI bit.
+*/
+type VLANTag struct{
+	TPID layers.EthernetType
+	VLANIdentifier uint16
+	Priority uint8
+	DropEligible bool
+}
+
+/*
+The LayerType of EthLayer2. It is registered with a decoder, so that it can be
+used with gopacket.NewPacket.
+*/
+var LayerTypeEthLayer2 = gopacket.RegisterLayerType(1200,gopacket.LayerTypeMetadata{Name:"EthLayer2",Decoder:gopacket.DecodeFunc(decodeEthLayer2)})
+
+var ethLayerClass = gopacket.NewLayerClass([]gopacket.LayerType{LayerTypeEthLayer2,layers.LayerTypeEthernet})
+
+func isTPID(t layers.EthernetType) bool {
+	switch t {
+	case layers.EthernetTypeDot1Q,layers.EthernetTypeQinQ,0x9100:
+		return true
+	}
+	return false
+}
+
 /*
 Ethernet Layer + VLAN extension.
+
+VLANIdentifier is the VID of the innermost tag (the C-VID), SVLANIdentifier is
+the VID of the outermost tag of a frame with two or more tags (the S-VID).
+Priority (PCP) and DropEligible (DEI) are those of the innermost tag.
+Tags holds the complete tag stack, outermost first.
+
+On serialization, the tag stack in Tags is emitted, with the innermost and
+outermost VID replaced by VLANIdentifier and SVLANIdentifier, and the
+innermost PCP and DEI replaced by Priority and DropEligible. If Tags is empty,
+an S-Tag is emitted for a non-zero SVLANIdentifier and a C-Tag for a non-zero
+VLANIdentifier or Priority (a priority tag). Both carry Priority and
+DropEligible.
+
+802.3 frames are decoded with their 802.2 LLC header (IsLLC) and, if present,
+their SNAP header (IsSNAP). For SNAP frames with an RFC1042 or 802.1H OUI,
+EthernetType is the SNAP type, for other LLC frames (including vendor specific
+SNAP frames) it is EthernetTypeLLC. On serialization, the LLC and SNAP
+headers are emitted if IsLLC is set, and the length field is filled in.
+
+EthLayer2 implements gopacket.DecodingLayer. In a gopacket.DecodingLayerParser,
+it decodes LayerTypeEthernet, including the VLAN tags and LLC/SNAP headers.
 */
 type EthLayer2 struct{
 	layers.Ethernet
 	VLANIdentifier uint16
+	SVLANIdentifier uint16
+	Priority uint8
+	DropEligible bool
+	Tags []VLANTag
+	IsLLC bool
+	IsSNAP bool
+	LLC layers.LLC
+	SNAP layers.SNAP
 	vlan layers.Dot1Q
 	
 }
 func (e *EthLayer2) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) (err error) {
 	err = e.Ethernet.DecodeFromBytes(data,df)
 	e.VLANIdentifier = 0
+	e.SVLANIdentifier = 0
+	e.Priority = 0
+	e.DropEligible = false
+	e.Tags = e.Tags[:0]
+	e.IsLLC = false
+	e.IsSNAP = false
 	if err!=nil { return }
-	if e.EthernetType == layers.EthernetTypeDot1Q {
+	/* layers.Ethernet leaves Length untouched for Ethernet II frames. */
+	if e.EthernetType!=layers.EthernetTypeLLC { e.Length = 0 }
+	for isTPID(e.EthernetType) {
 		err = e.vlan.DecodeFromBytes(e.Payload,df)
+		if err!=nil { return }
+		e.Tags = append(e.Tags,VLANTag{e.EthernetType,e.vlan.VLANIdentifier,e.vlan.Priority,e.vlan.DropEligible})
 		e.Payload = e.vlan.Payload
 		e.EthernetType = e.vlan.Type
-		e.VLANIdentifier = e.vlan.VLANIdentifier
+		/* A length field behind a tag. */
+		if e.EthernetType<0x0600 {
+			e.Length = uint16(e.EthernetType)
+			e.EthernetType = layers.EthernetTypeLLC
+			if int(e.Length)<len(e.Payload) { e.Payload = e.Payload[:e.Length] }
+		}
+	}
+	if e.EthernetType==layers.EthernetTypeLLC {
+		err = e.decodeLLC(df)
+		if err!=nil { return }
+	}
+	if n := len(e.Tags); n>0 {
+		e.VLANIdentifier = e.Tags[n-1].VLANIdentifier
+		e.Priority = e.Tags[n-1].Priority
+		e.DropEligible = e.Tags[n-1].DropEligible
+		if n>1 { e.SVLANIdentifier = e.Tags[0].VLANIdentifier }
+	}
+	return
+}
+
+func (e *EthLayer2) LayerType() gopacket.LayerType {
+	return LayerTypeEthLayer2
+}
+func (e *EthLayer2) CanDecode() gopacket.LayerClass {
+	return ethLayerClass
+}
+
+// Returns the LayerType of the payload, behind the VLAN tags and LLC/SNAP headers.
+func (e *EthLayer2) NextLayerType() gopacket.LayerType {
+	if e.EthernetType==layers.EthernetTypeLLC {
+		if e.IsLLC && e.LLC.DSAP==SAP_STP { return layers.LayerTypeSTP }
+		return gopacket.LayerTypePayload
+	}
+	return e.EthernetType.LayerType()
+}
+func decodeEthLayer2(data []byte, p gopacket.PacketBuilder) error {
+	e := new(EthLayer2)
+	err := e.DecodeFromBytes(data,p)
+	if err!=nil { return err }
+	p.AddLayer(e)
+	p.SetLinkLayer(e)
+	return p.NextDecoder(e.NextLayerType())
+}
+
+// Returns the number of tags, SerializeTo emits.
+func (e *EthLayer2) NumTags() int {
+	if len(e.Tags)!=0 { return len(e.Tags) }
+	n := 0
+	if e.SVLANIdentifier!=0 { n++ }
+	if e.VLANIdentifier!=0 || e.Priority!=0 || e.DropEligible { n++ }
+	return n
+}
+
+// Returns the i-th tag (outermost first), SerializeTo emits.
+func (e *EthLayer2) Tag(i int) (t VLANTag) {
+	n := len(e.Tags)
+	if n==0 {
+		if i==0 && e.SVLANIdentifier!=0 {
+			return VLANTag{layers.EthernetTypeQinQ,e.SVLANIdentifier,e.Priority,e.DropEligible}
+		}
+		return VLANTag{layers.EthernetTypeDot1Q,e.VLANIdentifier,e.Priority,e.DropEligible}
+	}
+	t = e.Tags[i]
+	if i==n-1 {
+		t.VLANIdentifier = e.VLANIdentifier
+		t.Priority = e.Priority
+		t.DropEligible = e.DropEligible
+	} else if i==0 {
+		t.VLANIdentifier = e.SVLANIdentifier
 	}
 	return
 }
+
+// Copies the tag stack from another frame (for example: the request, a reply is sent to).
+func (e *EthLayer2) CopyTags(o *EthLayer2) {
+	e.VLANIdentifier = o.VLANIdentifier
+	e.SVLANIdentifier = o.SVLANIdentifier
+	e.Priority = o.Priority
+	e.DropEligible = o.DropEligible
+	e.Tags = append(e.Tags[:0],o.Tags...)
+}
+
 func (e *EthLayer2) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) (err error) {
-	if e.VLANIdentifier!=0 {
-		e.vlan.Type = e.EthernetType
-		e.EthernetType = layers.EthernetTypeDot1Q
-		defer func() { e.EthernetType = e.vlan.Type } ()
-		e.vlan.VLANIdentifier = e.VLANIdentifier
-		err = e.vlan.SerializeTo(b,opts)
+	n := e.NumTags()
+	if e.IsLLC {
+		var length int
+		length,err = e.serializeLLC(b,opts)
+		if err!=nil { return }
+		typ,olen := e.EthernetType,e.Length
+		defer func() { e.EthernetType,e.Length = typ,olen } ()
+		if n!=0 {
+			e.EthernetType = layers.EthernetType(length)
+			e.Length = 0
+		} else {
+			e.EthernetType = layers.EthernetTypeLLC
+			e.Length = uint16(length)
+		}
+	}
+	if n!=0 {
+		typ := e.EthernetType
+		defer func() { e.EthernetType = typ } ()
+		for i := n-1; i>=0; i-- {
+			t := e.Tag(i)
+			e.vlan.VLANIdentifier = t.VLANIdentifier
+			e.vlan.Priority = t.Priority
+			e.vlan.DropEligible = t.DropEligible
+			e.vlan.Type = e.EthernetType
+			err = e.vlan.SerializeTo(b,opts)
+			if err!=nil { return }
+			e.EthernetType = t.TPID
+		}
 	}
-	if err==nil { err = e.Ethernet.SerializeTo(b,opts) }
+	err = e.Ethernet.SerializeTo(b,opts)
 	return
 }
 func (e *EthLayer2) String() string {
+	if e.SVLANIdentifier!=0 {
+		return fmt.Sprintf("%v->%v [%v.%v] (%v)",e.SrcMAC,e.DstMAC,e.SVLANIdentifier,e.VLANIdentifier,e.EthernetType)
+	}
 	return fmt.Sprintf("%v->%v [%v] (%v)",e.SrcMAC,e.DstMAC,e.VLANIdentifier,e.EthernetType)
 }
-
@@ -0,0 +1,130 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package eth
+
+import "github.com/google/gopacket"
+import "github.com/google/gopacket/layers"
+import "fmt"
+
+/* Well known LLC SAPs. */
+const (
+	SAP_SNAP = 0xAA /* Subnetwork Access Protocol, RFC1042 */
+	SAP_STP  = 0x42 /* Spanning Tree (IEEE 802.1D) */
+)
+
+var ELLCTooShort = fmt.Errorf("LLC header too short")
+
+/*
+Reports, whether a SNAP header with the given OUI carries an EtherType in it's
+protocol identifier: 00-00-00 (RFC1042) and 00-00-F8 (802.1H bridge tunnel).
+Other OUIs carry vendor specific protocol identifiers.
+*/
+func IsEtherTypeOUI(oui []byte) bool {
+	if len(oui)!=3 || oui[0]!=0 || oui[1]!=0 { return false }
+	return oui[2]==0 || oui[2]==0xF8
+}
+
+/*
+Decodes the 802.2 LLC header of an 802.3 frame (e.EthernetType being a length
+field), and the SNAP header following it (RFC1042).
+
+For SNAP frames, Payload is set to the encapsulated packet. If the OUI denotes
+an EtherType (see IsEtherTypeOUI), EthernetType is set to the SNAP type, so that
+IP over SNAP is handled like Ethernet II. For vendor specific SNAP frames (such
+as CDP) and other LLC frames, EthernetType stays EthernetTypeLLC. For non-SNAP
+LLC frames, Payload is the LLC payload.
+*/
+func (e *EthLayer2) decodeLLC(df gopacket.DecodeFeedback) error {
+	data := e.Payload
+	if len(data)<3 { return ELLCTooShort }
+	l := &e.LLC
+	l.DSAP = data[0]&0xFE
+	l.IG = data[0]&1!=0
+	l.SSAP = data[1]&0xFE
+	l.CR = data[1]&1!=0
+	l.Control = uint16(data[2])
+	/* I- and S-format PDUs have a 16 bit control field, U-format PDUs an 8 bit one. */
+	n := 3
+	if l.Control&3!=3 {
+		if len(data)<4 { return ELLCTooShort }
+		l.Control = l.Control<<8|uint16(data[3])
+		n = 4
+	}
+	l.BaseLayer = layers.BaseLayer{data[:n],data[n:]}
+	e.IsLLC = true
+	e.EthernetType = layers.EthernetTypeLLC
+	e.Payload = l.Payload
+	
+	if l.DSAP==SAP_SNAP && l.SSAP==SAP_SNAP && n==3 {
+		data = l.Payload
+		if len(data)<5 { return ELLCTooShort }
+		e.SNAP.OrganizationalCode = data[:3]
+		e.SNAP.Type = layers.EthernetType(uint16(data[3])<<8|uint16(data[4]))
+		e.SNAP.BaseLayer = layers.BaseLayer{data[:5],data[5:]}
+		e.IsSNAP = true
+		e.Payload = e.SNAP.Payload
+		if IsEtherTypeOUI(e.SNAP.OrganizationalCode) { e.EthernetType = e.SNAP.Type }
+	}
+	return nil
+}
+
+/*
+Prepends the SNAP and LLC headers and returns the length of the 802.3 frame's
+payload.
+*/
+func (e *EthLayer2) serializeLLC(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) (length int, err error) {
+	if e.IsSNAP {
+		if len(e.SNAP.OrganizationalCode)!=3 { e.SNAP.OrganizationalCode = []byte{0,0,0} }
+		/* Vendor specific SNAP frames keep their protocol identifier. */
+		if IsEtherTypeOUI(e.SNAP.OrganizationalCode) { e.SNAP.Type = e.EthernetType }
+		err = e.SNAP.SerializeTo(b,opts)
+		if err!=nil { return }
+	}
+	err = e.LLC.SerializeTo(b,opts)
+	if err!=nil { return }
+	length = len(b.Bytes())
+	if length>=0x0600 { err = fmt.Errorf("invalid 802.3 length %v",length) }
+	return
+}
+
+/*
+Sets up an 802.3 frame with an LLC header of dsap, ssap and control, without
+SNAP header. EthernetType is not sent; use SetSNAP to carry it.
+*/
+func (e *EthLayer2) SetLLC(dsap, ssap uint8, control uint16) {
+	e.IsLLC = true
+	e.IsSNAP = false
+	e.LLC = layers.LLC{DSAP:dsap,SSAP:ssap,Control:control}
+}
+
+/*
+Sets up an 802.3 frame with a SNAP header (RFC1042), carrying EthernetType.
+*/
+func (e *EthLayer2) SetSNAP() {
+	e.IsLLC = true
+	e.IsSNAP = true
+	e.LLC = layers.LLC{DSAP:SAP_SNAP,SSAP:SAP_SNAP,Control:3}
+	e.SNAP.OrganizationalCode = []byte{0,0,0}
+}
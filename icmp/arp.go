@@ -33,7 +33,7 @@ import "time"
 import "container/list"
 import "net"
 
-func (h *Host) arp(i *ip.IPLayerPart, po PacketOutput) {
+func (h *Host) arp(e *eth.EthLayer2, i *ip.IPLayerPart, po PacketOutput) {
 	var sendchain *list.List
 	sendchain = nil
 	sh := i.AR4.SourceHwAddress
@@ -46,27 +46,37 @@ func (h *Host) arp(i *ip.IPLayerPart, po PacketOutput) {
 	ncache := h.ARP
 	ce := ncache.LookupOrCreate(sp)
 	defer ce.Unlock()
+	defer ncache.changed(ce,ce.shadow())
 	
-	isOurs := h.Host.Input(tp)
+	/* Broadcast and multicast addresses are never resolved. */
+	isOurs := h.Host.IsUnicast(tp)
 	
-	if isOurs || ce.State != ARP__PHANTOM_ {
+	/*
+	 * Proxy-ARP: answer for configured addresses or prefixes, but never for
+	 * gratuitous ARP packets (the sender announcing it's own address).
+	 */
+	if !isOurs && !net.IP(sp).Equal(net.IP(tp)) {
+		isOurs = h.Proxy.Match(tp)
+	}
+	
+	/* Permanent entries are never updated by ARP packets. */
+	if ce.State == ARP_PERMANENT {
+	} else if isOurs || ce.State != ARP__PHANTOM_ {
 		ce.Tstamp = time.Now()
 		ce.HWAddr = sh
 		ce.State = ARP_COMPLETE
-		sendchain = ce.Sendchain
-		ce.Sendchain = list.New()
+		ce.PlusEntry.Remove()
+		ce.SolicitationSendCounter = 0
+		sendchain = ncache.take(&ce.Sendchain)
 	}
 	
 	
 	if isOurs && i.AR4.Operation==layers.ARPRequest {
-		var ethout eth.EthLayer2
 		var arpout layers.ARP
-		ethout.VLANIdentifier = h.Vlan
-		ethout.SrcMAC = h.Mac
+		ethout := h.replyHeader(e,sh,layers.EthernetTypeARP)
 		arpout.SourceHwAddress = h.Mac
 		arpout.SourceProtAddress = tp
 		
-		ethout.DstMAC = sh
 		arpout.DstHwAddress = sh
 		arpout.DstProtAddress = sp
 		
@@ -86,17 +96,14 @@ func (h *Host) arp(i *ip.IPLayerPart, po PacketOutput) {
 }
 
 func (h *Host) arpSendSolicitation(src, dst net.IP, po PacketOutput) {
-	var ethout eth.EthLayer2
 	var arpout layers.ARP
 	
 	dh := net.HardwareAddr{0xff,0xff,0xff,0xff,0xff,0xff}
 	
-	ethout.VLANIdentifier = h.Vlan
-	ethout.SrcMAC = h.Mac
+	ethout := h.ethHeader(dh,layers.EthernetTypeARP,DSCP_CS6)
 	arpout.SourceHwAddress = h.Mac
 	arpout.SourceProtAddress = src
 	
-	ethout.DstMAC = dh
 	arpout.DstHwAddress = dh
 	arpout.DstProtAddress = dst
 	
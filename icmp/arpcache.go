@@ -60,6 +60,12 @@ const (
 	
 	ARP_INCOMPLETE
 	ARP_COMPLETE
+	
+	/*
+	 * Administratively configured entry. It is never altered by received
+	 * ARP packets, never times out and is never evicted.
+	 */
+	ARP_PERMANENT
 )
 
 
@@ -70,21 +76,34 @@ type ArpCe struct {
 	IPAddr IPv4Addr
 	HWAddr net.HardwareAddr
 	
+	LocalIPAddr IPv4Addr /* Use as Source IP address, when sending ARP requests. */
+	
 	Tstamp time.Time
 	
+	SolicitationSendCounter int
+	
 	Entry Member
 	
+	PlusEntry Member /* Retrans-List-Entry */
+	
 	Sendchain *list.List
 }
 func (a *ArpCe) Init() *ArpCe {
 	a.Entry.Value = a
+	a.PlusEntry.Value = a
 	a.State = ARP__PHANTOM_
 	a.Sendchain = list.New()
 	return a
 }
 
 type ArpCache struct {
+	QueueLimits /* First, for the 64-bit alignment of it's counters. */
+	
 	Entries List
+	
+	/* Retrans : Entries in INCOMPLETE-State */
+	Retrans List
+	
 	Maxsize int
 	
 	Ipmap   map[IPv4Addr]*ArpCe
@@ -97,10 +116,14 @@ type ArpCache struct {
 	SoftTmoDiff time.Duration /* Soft Timeout Difference */
 	
 	mutex sync.RWMutex
+	
+	events subscribers
 }
 func (a *ArpCache) Init() *ArpCache {
 	a.Entries.Init()
+	a.Retrans.Init()
 	a.Maxsize = 128000
+	a.initLimits()
 	a.Timeout = 60 * time.Second
 	a.SoftTmoDiff = 3 * time.Second
 	a.Ipmap = make(map[IPv4Addr]*ArpCe)
@@ -108,25 +131,42 @@ func (a *ArpCache) Init() *ArpCache {
 }
 // The returned *ArpCe is locked.
 func (n *ArpCache) LookupOrCreate(ip net.IP) *ArpCe {
-	n.mutex.Lock(); defer n.mutex.Unlock();
+	n.mutex.Lock()
 	sp := NewIPv4Addr(ip)
 	nce,ok := n.Ipmap[sp]
-	if ok { nce.Lock(); return nce }
+	if ok { nce.Lock(); n.mutex.Unlock(); return nce }
 	nce = new(ArpCe).Init()
 	nce.IPAddr = sp
+	nce.Tstamp = time.Now() /* Not evicted, before the caller got it. */
 	n.Ipmap[sp] = nce
+	
+	/*
+	 * The oldest entries are evicted, as long as the cache is full, or as they
+	 * have timed out. Entries in the INCOMPLETE state are left to the timer,
+	 * unless the cache is full.
+	 * The packets queued for an evicted entry are reported as undeliverable.
+	 */
+	var evicted []*ArpCe
 	for {
 		roe := n.Entries.Front()
 		if roe == nil { break }
 		oe := roe.Value.(*ArpCe)
-		if oe.State != ARP__PHANTOM_ {
-		} else if n.Entries.Len() > n.Maxsize {
-		} else if time.Since(oe.Tstamp) < n.Timeout {
-		} else { break }
+		oe.Lock()
+		if n.Entries.Len() < n.Maxsize {
+			if oe.State == ARP_INCOMPLETE || time.Since(oe.Tstamp) < n.Timeout { oe.Unlock(); break }
+		}
 		oe.Entry.Remove()
+		oe.PlusEntry.Remove()
+		n.postpone(n.take(&oe.Sendchain))
 		delete(n.Ipmap,oe.IPAddr)
+		oe.Unlock()
+		evicted = append(evicted,oe)
 	}
 	n.Entries.PushBack(&nce.Entry)
+	n.mutex.Unlock()
+	
+	/* The evicted entries are locked for the event, while no lock is held. */
+	for _,oe := range evicted { n.evicted(oe) }
 	nce.Lock()
 	return nce
 }
@@ -148,3 +188,84 @@ func (n *ArpCache) LookupValidOnly(ip net.IP) *ArpCe {
 	return nce
 }
 
+
+/*
+ * Adds or replaces a permanent entry. Packets, that were queued awaiting
+ * address resolution are returned and must be sent by the caller.
+ */
+func (n *ArpCache) AddPermanent(ip net.IP, hw net.HardwareAddr) (sendchain *list.List) {
+	nce := n.LookupOrCreate(ip)
+	defer nce.Unlock()
+	defer n.changed(nce,nce.shadow())
+	nce.State = ARP_PERMANENT
+	nce.HWAddr = hw
+	nce.Tstamp = time.Now()
+	
+	/* Permanent entries are not subject to the eviction or any timer. */
+	nce.Entry.Remove()	
+	nce.PlusEntry.Remove()
+	
+	sendchain = n.take(&nce.Sendchain)
+	return
+}
+// The *ArpCe must be locked. This methods calls nce.Unlock().
+func (n *ArpCache) removeEntry(nce *ArpCe) {
+	nce.Entry.Remove()
+	nce.PlusEntry.Remove()
+	old := nce.shadow()
+	nce.State = ARP__PHANTOM_
+	n.changed(nce,old)
+	nce.Unlock()
+	n.mutex.Lock(); defer n.mutex.Unlock();
+	if ptr,ok := n.Ipmap[nce.IPAddr]; ok && ptr==nce {
+		delete(n.Ipmap,nce.IPAddr)
+	}
+}
+
+/*
+ * Retransmits ARP requests for entries in the INCOMPLETE state, and gives up
+ * after nMAX_UNICAST_SOLICIT transmissions (like RFC-4861 7.2.2 does for
+ * Neighbor Solicitations). Must be called periodically.
+ */
+func (n *ArpCache) TimerEvent(h *Host, po PacketOutput, NOW time.Time) {
+	if failed := n.takePostponed(); failed!=nil { h.resolutionFailed(failed,po) }
+	for {
+		le := n.Retrans.MoveFrontToBack()
+		if le==nil { break }
+		nce := le.Value.(*ArpCe)
+		nce.Lock()
+		if time.Since(nce.Tstamp) < nRETRANS_TIMER { nce.Unlock(); break }
+		if nce.State != ARP_INCOMPLETE {
+			/* The Retrans-Queue is for INCOMPLETE-state only. */
+			nce.PlusEntry.Remove()
+			nce.Unlock()
+			continue
+		}
+		nce.SolicitationSendCounter++
+		if nce.SolicitationSendCounter >= nMAX_UNICAST_SOLICIT {
+			failed := n.take(&nce.Sendchain)
+			n.removeEntry(nce) /* This methods calls nce.Unlock() */
+			h.resolutionFailed(failed,po)
+			continue
+		}
+		h.arpSendSolicitation(nce.LocalIPAddr.IP(),nce.IPAddr.IP(),po)
+		nce.Tstamp = NOW
+		nce.Entry.MoveToBack()
+		nce.Unlock()
+	}
+}
+
+// Removes a permanent entry. Returns false if no permanent entry exists.
+func (n *ArpCache) RemovePermanent(ip net.IP) bool {
+	n.mutex.Lock(); defer n.mutex.Unlock()
+	sp := NewIPv4Addr(ip)
+	nce,ok := n.Ipmap[sp]
+	if !ok { return false }
+	nce.Lock(); defer nce.Unlock()
+	if nce.State != ARP_PERMANENT { return false }
+	old := nce.shadow()
+	nce.State = ARP__PHANTOM_
+	n.changed(nce,old)
+	delete(n.Ipmap,sp)
+	return true
+}
@@ -30,39 +30,56 @@ import "container/list"
 import "time"
 
 func (h *Host) ResolutionV4(l *list.List, srcIP, destIP net.IP, po PacketOutput) error {
-	isBroadcast := func () bool { return false }
-	// TODO: check multicast/broadcast.
-	
-	if isBroadcast() {
+	if d4 := destIP.To4(); d4!=nil { destIP = d4 }
+	if h.Host.IsBroadcast(destIP) {
 		hwaddr := net.HardwareAddr{0xff,0xff,0xff,0xff,0xff,0xff}
-		h.send(l,hwaddr,po,layers.EthernetTypeIPv6)
+		h.send(l,hwaddr,po,layers.EthernetTypeIPv4)
+	}else if destIP[0]&0xf0==0xe0 { /* Multicast */
+		/*
+		 * RFC1112 6.4:
+		 *   An IP host group address is mapped to an Ethernet multicast
+		 *   address by placing the low-order 23-bits of the IP address into
+		 *   the low-order 23 bits of the Ethernet multicast address
+		 *   01-00-5E-00-00-00 (hex).
+		 */
+		hwaddr := net.HardwareAddr{0x01,0x00,0x5e,destIP[1]&0x7f,destIP[2],destIP[3]}
+		h.send(l,hwaddr,po,layers.EthernetTypeIPv4)
 	}else{
 		ncache := h.ARP
 		
 		nce := ncache.LookupOrCreate(destIP)
 		defer nce.Unlock()
+		defer ncache.changed(nce,nce.shadow())
 		
 		switch nce.State {
 		case ARP__PHANTOM_:
 			nce.State = ARP_INCOMPLETE
 			nce.Tstamp = time.Now()
+			nce.LocalIPAddr = NewIPv4Addr(srcIP)
+			nce.SolicitationSendCounter = 0
 			nce.Entry.MoveToBack()
+			ncache.Retrans.PushBack(&nce.PlusEntry)
 			
 			h.arpSendSolicitation(srcIP,destIP,po)
 			fallthrough
 		case ARP_INCOMPLETE:
-			nce.Sendchain.PushBackList(l)
+			/*
+			 * Packets, dropped by the queue limits, are reported like those of
+			 * a failed resolution, once the entry is unlocked.
+			 */
+			if dropped := ncache.enqueue(nce.Sendchain,l); dropped!=nil { go h.resolutionFailed(dropped,po) }
 			return nil
 		}
 		
 		since := time.Since(nce.Tstamp)
 		
 		// When approaching expiration, send new ARP request
-		if since > (ncache.Timeout+ncache.SoftTmoDiff) {
+		if nce.State == ARP_PERMANENT {
+		} else if since > (ncache.Timeout+ncache.SoftTmoDiff) {
 			h.arpSendSolicitation(srcIP,destIP,po)
 		}
 		
-		go h.send(l,nce.HWAddr,po,layers.EthernetTypeIPv6)
+		go h.send(l,nce.HWAddr,po,layers.EthernetTypeIPv4)
 	}
 	return nil
 }
@@ -110,6 +110,10 @@ func (m *Member) Remove() {
 	if m.self==nil { return }
 	
 	p.self.Remove(m.self)
+	
+	/* Detach, so that this Member can be added to a List again. */
+	m.parent = nil
+	m.self = nil
 }
 func (m *Member) MoveToBack() {
 	p := m.parent
@@ -0,0 +1,81 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package icmp
+
+import "github.com/maxymania/ipsolution/ip"
+import "net"
+
+/*
+ * Reports, whether dst can be reached through this interface: IPv4
+ * destinations are resolved directly, IPv6 destinations must be on-link,
+ * multicast, or there must be a default router.
+ */
+func (h *Host) Reachable(dst net.IP) bool {
+	if d4 := dst.To4(); d4!=nil { return h.Host.SelectSource(d4)!=nil }
+	if len(dst)!=16 { return false }
+	if dst[0]==0xff || h.Host.IsOnLink(dst) { return true }
+	return h.NC6!=nil && h.NC6.Routers.Len()>0
+}
+
+func (h *Host) destination(dst net.IP) ip.Destination {
+	d := h.Host.Destination(dst)
+	if d.Usable { d.Usable = h.Reachable(dst) }
+	return d
+}
+
+func sortedIPs(ds []ip.Destination) []net.IP {
+	ip.SortDestinations(ds)
+	res := make([]net.IP,len(ds))
+	for i := range ds { res[i] = ds[i].IP }
+	return res
+}
+
+/*
+ * Orders candidate destinations (for example, the AAAA and A records of a
+ * name) by RFC 6724 6, most preferred first. Unreachable destinations and
+ * those without a source address are sorted last.
+ */
+func (h *Host) SortDestinations(dsts []net.IP) []net.IP {
+	ds := make([]ip.Destination,len(dsts))
+	for i,d := range dsts { ds[i] = h.destination(d) }
+	return sortedIPs(ds)
+}
+
+/*
+ * Orders candidate destinations by RFC 6724 6. Each destination is evaluated
+ * on the interface, it is routed through (see Route).
+ */
+func (n *Node) SortDestinations(dsts []net.IP) []net.IP {
+	ds := make([]ip.Destination,len(dsts))
+	for i,d := range dsts {
+		h,err := n.Route(&net.IPAddr{IP:d})
+		if err!=nil {
+			ds[i].IP = d
+			continue
+		}
+		ds[i] = h.destination(d)
+	}
+	return sortedIPs(ds)
+}
@@ -0,0 +1,112 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package icmp
+
+import "github.com/maxymania/ipsolution/eth"
+import "github.com/google/gopacket"
+import "github.com/google/gopacket/layers"
+
+import "sync"
+import "fmt"
+import "net"
+
+var EHandlerExists = fmt.Errorf("EtherType handler exists")
+var EReservedType = fmt.Errorf("EtherType is handled by the IP stack")
+
+/*
+ * Handles the frames of an EtherType, registered with a Host's EtherTypes.
+ * The FrameWriter sends replies with the VLAN tags of e.
+ */
+type EtherTypeHandler interface{
+	HandleFrame(e *eth.EthLayer2, w *FrameWriter) error
+}
+
+type EtherTypeHandlerFunc func(e *eth.EthLayer2, w *FrameWriter) error
+func (f EtherTypeHandlerFunc) HandleFrame(e *eth.EthLayer2, w *FrameWriter) error {
+	return f(e,w)
+}
+
+/*
+ * A table of EtherType handlers. IPv4, IPv6 and ARP are handled by the Host
+ * itself and can not be registered.
+ */
+type EtherTypeRegistry struct{
+	handlers map[layers.EthernetType]EtherTypeHandler
+	mutex sync.RWMutex
+}
+func (r *EtherTypeRegistry) Register(t layers.EthernetType, hd EtherTypeHandler) error {
+	switch t {
+	case layers.EthernetTypeIPv4,layers.EthernetTypeIPv6,layers.EthernetTypeARP,layers.EthernetTypeLLC:
+		return EReservedType
+	}
+	r.mutex.Lock(); defer r.mutex.Unlock()
+	if _,ok := r.handlers[t]; ok { return EHandlerExists }
+	if r.handlers==nil { r.handlers = make(map[layers.EthernetType]EtherTypeHandler) }
+	r.handlers[t] = hd
+	return nil
+}
+func (r *EtherTypeRegistry) Unregister(t layers.EthernetType) {
+	r.mutex.Lock(); defer r.mutex.Unlock()
+	delete(r.handlers,t)
+}
+func (r *EtherTypeRegistry) Lookup(t layers.EthernetType) EtherTypeHandler {
+	r.mutex.RLock(); defer r.mutex.RUnlock()
+	return r.handlers[t]
+}
+
+/*
+ * The send path of EtherType handlers. Frames are sent from the Host's Mac.
+ * If Request is set, they carry it's VLAN tags (a reply), otherwise the
+ * Host's Vlan and SVlan.
+ *
+ * A FrameWriter is a PacketOutput too: WritePacketData writes complete
+ * frames to Output, unchanged.
+ */
+type FrameWriter struct{
+	Host *Host
+	Output PacketOutput
+	Request *eth.EthLayer2
+}
+
+/* Returns a FrameWriter for frames, originated by the Host, sent through Output. */
+func (h *Host) FrameWriter() *FrameWriter {
+	return &FrameWriter{h,h.Output,nil}
+}
+
+func (w *FrameWriter) WriteFrame(dst net.HardwareAddr, etype layers.EthernetType, payload []byte) error {
+	var e eth.EthLayer2
+	if w.Request!=nil {
+		e = w.Host.replyHeader(w.Request,dst,etype)
+	} else {
+		e = w.Host.ethHeader(dst,etype,0)
+	}
+	b := gopacket.NewSerializeBufferExpectedSize(len(payload)+22,0)
+	err := gopacket.SerializeLayers(b,gopacket.SerializeOptions{true,true},&e,gopacket.Payload(payload))
+	if err!=nil { return err }
+	return w.Output.WritePacketData(b.Bytes())
+}
+func (w *FrameWriter) WritePacketData(data []byte) error {
+	return w.Output.WritePacketData(data)
+}
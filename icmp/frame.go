@@ -0,0 +1,63 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package icmp
+
+import "github.com/maxymania/ipsolution/ip"
+import "github.com/maxymania/ipsolution/eth"
+import "github.com/google/gopacket"
+import "github.com/google/gopacket/layers"
+
+/*
+ * Receives decoded frames, that are not handled by the IP layer, such as
+ * 802.2 LLC frames (for example: Spanning Tree BPDUs).
+ */
+type FrameHandler interface{
+	InputFrame(e *eth.EthLayer2, po PacketOutput) error
+}
+
+/*
+ * Processes a decoded frame. IPv4, IPv6 and ARP (including those carried in
+ * a SNAP header) are decoded and passed to Input. Frames of other EtherTypes
+ * are passed to the handler registered in EtherTypes, other LLC frames
+ * (including vendor specific SNAP frames) to LLCHandler, if they pass the
+ * MACFilter.
+ */
+func (h *Host) InputFrame(e *eth.EthLayer2, po PacketOutput) error {
+	switch e.EthernetType {
+	case layers.EthernetTypeIPv4,layers.EthernetTypeIPv6,layers.EthernetTypeARP:
+		var i ip.IPLayerPart
+		err := i.DecodeType(e.EthernetType.LayerType(),e.Payload,gopacket.NilDecodeFeedback)
+		if err!=nil { return err }
+		return h.Input(e,&i,po)
+	}
+	if !h.acceptFrame(e) { return EFiltered }
+	if e.EthernetType==layers.EthernetTypeLLC {
+		if e.IsLLC && h.LLCHandler!=nil { return h.LLCHandler.InputFrame(e,po) }
+		return ENotSupp
+	}
+	hd := h.EtherTypes.Lookup(e.EthernetType)
+	if hd==nil { return ENotSupp }
+	return hd.HandleFrame(e,&FrameWriter{h,po,e})
+}
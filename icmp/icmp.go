@@ -32,6 +32,7 @@ import "github.com/google/gopacket/layers"
 import "fmt"
 import "net"
 import "container/list"
+import "time"
 
 var ENotSupp = fmt.Errorf("Protocol not supported")
 var EInvalid = fmt.Errorf("Protocol violation")
@@ -65,20 +66,48 @@ type Echo struct {
 }
 
 type Host struct{
+	/*
+	 * Destination MAC filter of received frames. First, for the 64-bit
+	 * alignment of it's counters.
+	 */
+	Filter MACFilter
+	
+	/* Interface index and name. The name is the zone of link-local addresses. */
+	Index int
+	Name string
+	
+	/* Output of this interface, used for timer-driven transmissions. */
+	Output PacketOutput
+	
 	NetN Notifyable
 	NetNv6 Notifyable
 	EchoSocket Notifyable
 	NC6 *Nd6Cache
 	ARP *ArpCache
 	Host *ip.IPHost
+	Proxy *ProxyTable /* Proxy-ARP/Proxy-ND entries, may be nil. */
 	Mac net.HardwareAddr
 	Vlan uint16
+	SVlan uint16 /* S-VID (802.1ad) of originated frames, 0 for none. */
+	PCP *PCPMap /* DSCP to 802.1p priority of originated frames, nil for PCP 0. */
+	LLCHandler FrameHandler /* Receives non-IP 802.2 LLC frames, may be nil. */
+	EtherTypes EtherTypeRegistry /* Handlers of other EtherTypes. */
+	Protocols ProtocolRegistry /* Handlers of IP protocols other than ICMP. */
+	raw rawTable
+	
+	/* Default IPv4 TTL (DefaultTTL if 0) and link MTU (1500 if 0). */
+	TTL uint8
+	MTU uint32
+	ipid uint32
 	
 	/* IPv6 */
 	CurHopLimit uint8
 	BaseReachableTime, ReachableTime uint32
 	IPv6MTU uint32
 	RetransTimer uint32
+	
+	/* RFC4862 5.1: 1 if 0, Duplicate Address Detection is disabled if negative. */
+	DupAddrDetectTransmits int
 }
 
 func copymac(i net.HardwareAddr) net.HardwareAddr {
@@ -123,11 +152,19 @@ func duV6ToV4(code layers.ICMPv6TypeCode) layers.ICMPv4TypeCode {
 
 
 func (h *Host) Input(e *eth.EthLayer2, i *ip.IPLayerPart, po PacketOutput) error {
+	if !h.acceptFrame(e) { return EFiltered }
 	if i.IsAR {
-		h.arp(i,po)
+		h.arp(e,i,po)
 		return nil
 	}
-	switch i.NextLayerType {
+	/*
+	 * Packets to multicast groups, that are not joined, are dropped. Neighbor
+	 * Solicitations for proxied targets (to their solicited-node group) are
+	 * accepted for Proxy-ND.
+	 */
+	if i.DstIP.IsMulticast() && !h.Host.Input(i.DstIP) && !h.proxiedSolicitation(i) { return EFiltered }
+	raw := h.Host.Input(i.DstIP) && h.raw.deliver(i)
+	switch i.NextType {
 		case layers.LayerTypeICMPv4:
 			// ICMPv4 must not be in IPv6 packet
 			if i.IsV6 { return EInvalid }
@@ -137,7 +174,20 @@ func (h *Host) Input(e *eth.EthLayer2, i *ip.IPLayerPart, po PacketOutput) error
 			if !i.IsV6 { return EInvalid }
 			return h.input6(e,i,po)
 	}
-	return ENotSupp
+	return h.inputProtocol(e,i,po,raw)
+}
+/*
+ * Reports, whether the packet is a Neighbor Solicitation, sent to the
+ * solicited-node group of a target, that is answered for by proxy.
+ */
+func (h *Host) proxiedSolicitation(i *ip.IPLayerPart) bool {
+	if !i.IsV6 || i.NextType!=layers.LayerTypeICMPv6 || len(i.Payload)<24 { return false }
+	if i.Payload[0]!=byte(layers.ICMPv6TypeNeighborSolicitation) { return false }
+	target := net.IP(i.Payload[8:24])
+	/* RFC4291 2.7.1: ff02::1:ffXX:XXXX, formed from the low-order 24 bits. */
+	d := i.DstIP
+	if !d.Equal(net.IP{0xff,0x02,0,0,0,0,0,0,0,0,0,1,0xff,target[13],target[14],target[15]}) { return false }
+	return h.Proxy.Match(target)
 }
 // ICMPv4 input function
 func (h *Host) input4(e *eth.EthLayer2, i *ip.IPLayerPart, po PacketOutput) (err error) {
@@ -147,19 +197,8 @@ func (h *Host) input4(e *eth.EthLayer2, i *ip.IPLayerPart, po PacketOutput) (err
 	switch icmp.TypeCode.Type() {
 	case layers.ICMPv4TypeEchoRequest:
 		icmp.TypeCode = layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoReply,icmp.TypeCode.Code())
-		// Flip addresses.
-		i.V4.SrcIP,i.V4.DstIP = i.V4.DstIP,i.V4.SrcIP
-		e.SrcMAC,e.DstMAC = h.Mac,e.SrcMAC
-		e.VLANIdentifier = h.Vlan
-		
-		// rewrite IPv4 fields.
-		i.V4.TOS = 0
-		i.V4.TTL = 64
-		
-		so := gopacket.SerializeOptions{true,true}
-		sb := gopacket.NewSerializeBufferExpectedSize(len(icmp.Payload)+128,0)
-		gopacket.SerializeLayers(sb,so,e,&i.V4,&icmp,gopacket.Payload(icmp.Payload))
-		po.WritePacketData(sb.Bytes())
+		// Reply on the VLAN tag stack, the request arrived on.
+		err = h.sendIP(i.SrcIP,layers.IPProtocolICMPv4,Stack{&icmp,gopacket.Payload(icmp.Payload)},h.echoOptions(e,i),po)
 	case layers.ICMPv4TypeEchoReply:
 		if h.EchoSocket==nil { return }
 		h.EchoSocket.Notify(&Echo{copydat(icmp.Contents),copydat(icmp.Payload),copyip(i.SrcIP)})
@@ -197,18 +236,8 @@ func (h *Host) input6(e *eth.EthLayer2, i *ip.IPLayerPart, po PacketOutput) (err
 	switch icmp.TypeCode.Type() {
 	case layers.ICMPv6TypeEchoRequest:
 		icmp.TypeCode = layers.CreateICMPv6TypeCode(layers.ICMPv6TypeEchoReply,icmp.TypeCode.Code())
-		// Flip addresses.
-		i.V6.SrcIP,i.V6.DstIP = i.V6.DstIP,i.V6.SrcIP
-		e.SrcMAC,e.DstMAC = e.DstMAC,e.SrcMAC
-		
-		// rewrite IPv6 fields.
-		i.V6.TrafficClass = 0
-		i.V6.HopLimit = 64
-		
-		so := gopacket.SerializeOptions{true,true}
-		sb := gopacket.NewSerializeBufferExpectedSize(len(icmp.Payload)+128,0)
-		gopacket.SerializeLayers(sb,so,e,&i.V6,&icmp,gopacket.Payload(icmp.Payload))
-		po.WritePacketData(sb.Bytes())
+		// Reply on the VLAN tag stack, the request arrived on.
+		err = h.sendIP(i.SrcIP,layers.IPProtocolICMPv6,Stack{&icmp,gopacket.Payload(icmp.Payload)},h.echoOptions(e,i),po)
 	case layers.ICMPv6TypeEchoReply:
 		if h.EchoSocket==nil { return }
 		h.EchoSocket.Notify(&Echo{copydat(icmp.Contents),copydat(icmp.Payload),copyip(i.SrcIP)})
@@ -251,7 +280,7 @@ func (h *Host) input6(e *eth.EthLayer2, i *ip.IPLayerPart, po PacketOutput) (err
 				copyip(i.SrcIP)})
 	case layers.ICMPv6TypeNeighborSolicitation:
 		if h.NC6==nil { return }
-		h.nd6NeighborSolicitation(i,&icmp,po)
+		h.nd6NeighborSolicitation(e,i,&icmp,po)
 	case layers.ICMPv6TypeNeighborAdvertisement:
 		if h.NC6==nil { return }
 		h.nd6NeighborAdvertisement(i,&icmp,po)
@@ -278,27 +307,53 @@ func (h *Host) input6(e *eth.EthLayer2, i *ip.IPLayerPart, po PacketOutput) (err
 	}
 	return
 }
-func (h *Host) send(l *list.List, dst net.HardwareAddr, po PacketOutput, etype layers.EthernetType) {
-	if l.Len()==0 { return }
-	var e eth.EthLayer2
+// Returns the Ethernet header for frames originated by this host.
+func (h *Host) ethHeader(dst net.HardwareAddr, etype layers.EthernetType, dscp uint8) (e eth.EthLayer2) {
 	e.SrcMAC = h.Mac
 	e.VLANIdentifier = h.Vlan
+	e.SVLANIdentifier = h.SVlan
+	e.Priority = h.PCP.Lookup(dscp)
 	e.DstMAC = dst
 	e.EthernetType = etype
-	
+	return
+}
+// Returns the Ethernet header for replies to 'req', using the same VLAN tag stack.
+func (h *Host) replyHeader(req *eth.EthLayer2, dst net.HardwareAddr, etype layers.EthernetType) (e eth.EthLayer2) {
+	e.SrcMAC = h.Mac
+	e.CopyTags(req)
+	e.DstMAC = dst
+	e.EthernetType = etype
+	return
+}
+func (h *Host) send(l *list.List, dst net.HardwareAddr, po PacketOutput, etype layers.EthernetType) {
+	if l.Len()==0 { return }
+	e := h.ethHeader(dst,etype,0)
+	h.sendClassified(l,&e,po)
+}
+// Sends the packet 'v' after 'delay', prepended with the Ethernet header 'e'.
+func (h *Host) sendDelayed(v interface{}, e eth.EthLayer2, delay time.Duration, po PacketOutput) {
+	time.AfterFunc(delay,func() {
+		h.sendFrame(v,&e,gopacket.SerializeOptions{true,true},po)
+	})
+}
+// Sends the packets in 'l', each one prepended with the Ethernet header 'e'.
+func (h *Host) sendFrames(l *list.List, e *eth.EthLayer2, po PacketOutput) {
 	op := gopacket.SerializeOptions{true,true}
 	for elem := l.Front(); elem!=nil; elem = elem.Next() {
-		switch ev := elem.Value.(type) {
-		case gopacket.SerializeBuffer:
-			if e.SerializeTo(ev,op)==nil {
-				po.WritePacketData(ev.Bytes())
-			}
-		case []byte:
-			ob := gopacket.NewSerializeBufferExpectedSize(len(ev)+20,0)
-			err := gopacket.SerializeLayers(ob,op,&e,gopacket.Payload(ev))
-			if err==nil {
-				po.WritePacketData(ob.Bytes())
-			}
+		h.sendFrame(elem.Value,e,op,po)
+	}
+}
+func (h *Host) sendFrame(v interface{}, e *eth.EthLayer2, op gopacket.SerializeOptions, po PacketOutput) {
+	switch ev := v.(type) {
+	case gopacket.SerializeBuffer:
+		if e.SerializeTo(ev,op)==nil {
+			po.WritePacketData(ev.Bytes())
+		}
+	case []byte:
+		ob := gopacket.NewSerializeBufferExpectedSize(len(ev)+20,0)
+		err := gopacket.SerializeLayers(ob,op,e,gopacket.Payload(ev))
+		if err==nil {
+			po.WritePacketData(ob.Bytes())
 		}
 	}
 }
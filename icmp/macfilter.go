@@ -0,0 +1,150 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package icmp
+
+import "github.com/maxymania/ipsolution/ip"
+import "github.com/maxymania/ipsolution/eth"
+import "sync/atomic"
+import "bytes"
+import "sync"
+import "fmt"
+import "net"
+
+var EFiltered = fmt.Errorf("Frame not addressed to us")
+
+/*
+ * Receive-side filter of destination MAC addresses.
+ *
+ * Accepted are frames to the Host's Mac, broadcasts and multicasts, that
+ * belong to a joined group: the groups, the Host's IPHost is a member of
+ * (33:33:xx:xx:xx:xx, RFC2464 7, and 01:00:5e:xx:xx:xx, RFC1112 6.4), and
+ * the addresses added with Join. With IPv6 Proxy entries, all
+ * solicited-node groups are accepted.
+ *
+ * In Promiscuous mode, all frames are accepted. In AllMulticast mode, all
+ * multicast frames are accepted.
+ */
+type MACFilter struct{
+	/* Updated atomically, kept first for 64-bit alignment. */
+	accepted, filtered uint64
+	
+	Promiscuous bool
+	AllMulticast bool
+	
+	joined map[[6]byte]int
+	mutex sync.RWMutex
+}
+
+/* Joins a multicast MAC address. Joins are counted. */
+func (f *MACFilter) Join(mac net.HardwareAddr) {
+	var k [6]byte
+	copy(k[:],mac)
+	f.mutex.Lock(); defer f.mutex.Unlock()
+	if f.joined==nil { f.joined = make(map[[6]byte]int) }
+	f.joined[k]++
+}
+
+/* Leaves a multicast MAC address, after it has been left as often as joined. */
+func (f *MACFilter) Leave(mac net.HardwareAddr) {
+	var k [6]byte
+	copy(k[:],mac)
+	f.mutex.Lock(); defer f.mutex.Unlock()
+	if f.joined[k]>1 {
+		f.joined[k]--
+	} else {
+		delete(f.joined,k)
+	}
+}
+func (f *MACFilter) isJoined(mac net.HardwareAddr) bool {
+	var k [6]byte
+	copy(k[:],mac)
+	f.mutex.RLock(); defer f.mutex.RUnlock()
+	return f.joined[k]>0
+}
+
+/* Number of accepted frames. */
+func (f *MACFilter) Accepted() uint64 { return atomic.LoadUint64(&f.accepted) }
+
+/* Number of filtered frames. */
+func (f *MACFilter) Filtered() uint64 { return atomic.LoadUint64(&f.filtered) }
+
+func isBroadcastMAC(mac net.HardwareAddr) bool {
+	for _,b := range mac { if b!=0xff { return false } }
+	return len(mac)==6
+}
+
+/*
+ * Reports, whether a multicast MAC address belongs to a group, this Host has
+ * joined (in it's IPHost or with MACFilter.Join).
+ */
+func (h *Host) isMulticastMember(mac net.HardwareAddr) bool {
+	switch {
+	case mac[0]==0x33 && mac[1]==0x33:
+		/* RFC2464 7: The low-order 32 bits of the group. */
+		if h.Host!=nil {
+			low := uint64(mac[2])<<24|uint64(mac[3])<<16|uint64(mac[4])<<8|uint64(mac[5])
+			h.Host.RLock()
+			for k := range h.Host.M6 {
+				if k.Lo&0xffffffff==low { h.Host.RUnlock(); return true }
+			}
+			h.Host.RUnlock()
+		}
+		/* Proxy-ND needs the solicited-node groups of the proxied addresses. */
+		if mac[2]==0xff && h.Proxy.hasV6() { return true }
+	case mac[0]==0x01 && mac[1]==0x00 && mac[2]==0x5e && mac[3]&0x80==0:
+		/* RFC1112 6.4: The low-order 23 bits of the group. */
+		if h.Host!=nil {
+			low := ip.Key4(mac[3])<<16|ip.Key4(mac[4])<<8|ip.Key4(mac[5])
+			h.Host.RLock()
+			for k := range h.Host.M4 {
+				if k&0x7fffff==low { h.Host.RUnlock(); return true }
+			}
+			h.Host.RUnlock()
+		}
+	}
+	return h.Filter.isJoined(mac)
+}
+
+/* Applies the MACFilter to a received frame. */
+func (h *Host) acceptFrame(e *eth.EthLayer2) bool {
+	f := &h.Filter
+	ok := true
+	dst := e.DstMAC
+	switch {
+	case f.Promiscuous || len(dst)!=6:
+	case dst[0]&1==0:
+		/* Without a MAC address, all unicast frames are accepted. */
+		ok = len(h.Mac)==0 || bytes.Equal(dst,h.Mac)
+	case isBroadcastMAC(dst),f.AllMulticast:
+	default:
+		ok = h.isMulticastMember(dst)
+	}
+	if ok {
+		atomic.AddUint64(&f.accepted,1)
+	} else {
+		atomic.AddUint64(&f.filtered,1)
+	}
+	return ok
+}
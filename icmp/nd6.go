@@ -26,6 +26,7 @@ SOFTWARE.
 package icmp
 
 import "github.com/maxymania/ipsolution/ip"
+import "github.com/maxymania/ipsolution/eth"
 import "github.com/google/gopacket"
 import "github.com/google/gopacket/layers"
 import "net"
@@ -74,9 +75,10 @@ type nd6_option_prefix struct {
 	PrefixLength  uint8
 	Flags         uint8
 	
-	ValidLifetime uint32
-	Reserved2     uint32
-	Prefix        [16]byte
+	ValidLifetime     uint32
+	PreferredLifetime uint32
+	Reserved2         uint32
+	Prefix            [16]byte
 }
 type nd6_option_mtu struct{
 	Ignore        uint32
@@ -91,6 +93,15 @@ func ipis0(ip net.IP) bool{
 	return true
 }
 
+/*
+ * RFC4861 7.1.1 / 7.1.2:
+ *   The IP Hop Limit field has a value of 255, i.e., the packet
+ *   could not possibly have been forwarded by a router.
+ */
+func nd6SendOptions(src net.IP) *SendOptions {
+	return &SendOptions{Src:src,TTL:255,TOS:DSCP_CS6<<2}
+}
+
 func (h *Host) nd6CreateNeighborSolicitation(
 	/* nil for, DAD */ src,
 	/*set for NUD,  nil for DAD & AR */ dest,
@@ -115,9 +126,8 @@ func (h *Host) nd6CreateNeighborSolicitation(
 	} else {
 		mac := h.Mac
 		macl := len(mac)+2
-		nm := (macl+7)&7
-		hml := macl>>3
-		if nm!=0 { hml++ }
+		nm := (8-macl&7)&7
+		hml := (macl+nm)>>3
 		
 		/* Source Link-Layer Address */
 		buf.WriteByte(1)
@@ -156,37 +166,29 @@ func (h *Host) nd6CreateNeighborSolicitation(
 		}
 	}
 	
-	var ip layers.IPv6
 	var icmp layers.ICMPv6
 	icmp.TypeCode = layers.CreateICMPv6TypeCode(135,0)
-	icmp.TypeBytes[0] = 0
-	icmp.TypeBytes[1] = 0
-	icmp.TypeBytes[2] = 0
-	icmp.TypeBytes[3] = 0
-	icmp.SetNetworkLayerForChecksum(&ip)
-	ip.TrafficClass = 0
-	ip.FlowLabel = rand.Uint32()
-	ip.SrcIP = src
-	ip.DstIP = dest
-	ip.HopLimit = 255
-	
-	SB := gopacket.NewSerializeBufferExpectedSize(1280,0)
-	op := gopacket.SerializeOptions{true,true}
-	err := gopacket.SerializeLayers(SB,op,&ip,&icmp,gopacket.Payload(buf.Bytes()))
-	if err!=nil { return nil,nil }
+	icmp.TypeBytes = make([]byte,4)
+	
+	SB := h.packetIP(dest,layers.IPProtocolICMPv6,Stack{&icmp,gopacket.Payload(buf.Bytes())},nd6SendOptions(src))
+	if SB==nil { return nil,nil }
 	
 	return SB,hwaddr
 }
 
 
-func (h *Host) nd6CreateNeighborAdvertisement(addr, rem net.IP,R,S,O bool) gopacket.SerializeBuffer{
+/*
+ * Creates a Neighbor Advertisement for the target 'addr'. If 'src' is nil, the
+ * target address is used as source address.
+ */
+func (h *Host) nd6CreateNeighborAdvertisement(src, addr, rem net.IP,R,S,O bool) gopacket.SerializeBuffer{
+	if len(src)==0 { src = addr }
 	buf := new(bytes.Buffer)
 	buf.Write(addr)
 	mac := h.Mac
 	macl := len(mac)+2
-	nm := (macl+7)&7
-	hml := macl>>3
-	if nm!=0 { hml++ }
+	nm := (8-macl&7)&7
+	hml := (macl+nm)>>3
 	
 	/* Target Link-Layer Address */
 	buf.WriteByte(2)
@@ -196,32 +198,18 @@ func (h *Host) nd6CreateNeighborAdvertisement(addr, rem net.IP,R,S,O bool) gopac
 		buf.WriteByte(0)
 	}
 	
-	var ip layers.IPv6
 	var icmp layers.ICMPv6
 	icmp.TypeCode = layers.CreateICMPv6TypeCode(136,0)
-	icmp.TypeBytes[0] = 0
-	icmp.TypeBytes[1] = 0
-	icmp.TypeBytes[2] = 0
-	icmp.TypeBytes[3] = 0
+	icmp.TypeBytes = make([]byte,4)
 	if R { icmp.TypeBytes[0]|=0x80 }
 	if S { icmp.TypeBytes[0]|=0x40 }
 	if O { icmp.TypeBytes[0]|=0x20 }
-	icmp.SetNetworkLayerForChecksum(&ip)
-	ip.TrafficClass = 0
-	ip.FlowLabel = rand.Uint32()
-	ip.SrcIP = addr
-	ip.DstIP = rem
-	ip.HopLimit = 255
-	
-	SB := gopacket.NewSerializeBufferExpectedSize(1280,0)
-	op := gopacket.SerializeOptions{true,true}
-	err := gopacket.SerializeLayers(SB,op,&ip,&icmp,gopacket.Payload(buf.Bytes()))
-	if err!=nil { return nil }
-	return SB
+	
+	return h.packetIP(rem,layers.IPProtocolICMPv6,Stack{&icmp,gopacket.Payload(buf.Bytes())},nd6SendOptions(src))
 }
 
 
-func (h *Host) nd6NeighborSolicitation(i *ip.IPLayerPart, cm *layers.ICMPv6, po PacketOutput) {
+func (h *Host) nd6NeighborSolicitation(e *eth.EthLayer2, i *ip.IPLayerPart, cm *layers.ICMPv6, po PacketOutput) {
 	/*
 	 * RFC-4861 7.1.1.  Validation of Neighbor Solicitations
 	 */
@@ -265,15 +253,65 @@ func (h *Host) nd6NeighborSolicitation(i *ip.IPLayerPart, cm *layers.ICMPv6, po
 	}
 	
 	taentry,target_is_local := h.Host.GetTarget6(target)
-	if target_is_local && taentry.Tentative { /* Tentative address! */
+	if target_is_local && taentry.State==ip.ADDR_TENTATIVE { /* Tentative address! */
 		
-		/* SLAAC failed! */
+		/*
+		 * RFC4862 5.4.3:
+		 *   If the source address of the Neighbor Solicitation is the
+		 *   unspecified address, the solicitation is from a node
+		 *   performing Duplicate Address Detection. The address is a
+		 *   duplicate.
+		 */
 		if source_addr_is_unspecified {
-			h.Host.SlaacFailedV6(taentry)
+			h.Host.DADFailed(taentry)
 		}
 		return
 	}
 	
+	/*
+	 * RFC-4389 Proxy-ND:
+	 *   Answer Solicitations for targets configured in the proxy table. As
+	 *   we don't own those addresses, we don't defend them in DAD.
+	 */
+	var nadv gopacket.SerializeBuffer
+	var nadvDelay time.Duration
+	if target_is_local && taentry.Anycast {
+		/*
+		 * RFC-4861 7.2.4:
+		 *   If the target address is an anycast address the sender SHOULD
+		 *   NOT set the Override flag.
+		 *
+		 * RFC-4861 7.2.7:
+		 *   If the Target Address is an anycast address, the sender SHOULD
+		 *   delay sending a response for a random time between 0 and
+		 *   MAX_ANYCAST_DELAY_TIME seconds.
+		 *
+		 * An anycast address is never used as source address.
+		 */
+		src := h.Host.SelectSource(i.SrcIP)
+		if src==nil { return }
+		nadv = h.nd6CreateNeighborAdvertisement(src,target,i.SrcIP,false,true,false)
+		nadvDelay = time.Duration(rand.Int63n(int64(nMAX_ANYCAST_DELAY_TIME)))
+	} else if target_is_local {
+		/*
+		 * RFC-4861 7.2.4:
+		 *   Otherwise, it SHOULD set the Override flag.
+		 */
+		nadv = h.nd6CreateNeighborAdvertisement(nil,target,i.SrcIP,false,true,true)
+	} else if !source_addr_is_unspecified && h.Proxy.Match(target) {
+		/*
+		 * RFC-4389 4.1.3.3:
+		 *   The proxy sends the Advertisement from one of it's own
+		 *   addresses, with the Override flag cleared.
+		 *
+		 * Without a link-local address, the proxy does not answer, as the
+		 * Advertisement would otherwise be sent from the proxied target.
+		 */
+		if src := h.Host.LinkLocal6(); src!=nil {
+			nadv = h.nd6CreateNeighborAdvertisement(src,target,i.SrcIP,false,true,false)
+		}
+	}
+	
 	
 	source_lla := net.HardwareAddr(nil)
 	
@@ -317,8 +355,9 @@ func (h *Host) nd6NeighborSolicitation(i *ip.IPLayerPart, cm *layers.ICMPv6, po
 		 */
 		
 		ncache := h.NC6
-		nce := ncache.LookupOrCreate(target)
+		nce := ncache.LookupOrCreate(i.SrcIP)
 		defer nce.Unlock()
+		defer ncache.changed(nce,nce.shadow())
 		
 		
 		/*
@@ -337,43 +376,48 @@ func (h *Host) nd6NeighborSolicitation(i *ip.IPLayerPart, cm *layers.ICMPv6, po
 		nonExisting := nce.State == ND6_NC__PHANTOM_
 		hwaddrUnEqual := !bytes.Equal([]byte(source_lla),nce.HWAddr)
 		
-		if incomplete||nonExisting||hwaddrUnEqual {
+		permanent := nce.State == ND6_NC_PERMANENT
+		
+		if !permanent && (incomplete||nonExisting||hwaddrUnEqual) {
 			nce.State = ND6_NC_STALE
 			nce.Tstamp = time.Now()
 			nce.HWAddr = source_lla
 			nce.Entry.MoveToBack()
 			nce.PlusEntry.Remove()
 		}
-		sendchain := nce.Sendchain
-		nce.Sendchain = list.New()
+		sendchain := ncache.take(&nce.Sendchain)
+		
+		ethout := h.replyHeader(e,source_lla,layers.EthernetTypeIPv6)
 		
-		if target_is_local {
-			/* Send Neighbor Advertisement. */
-			buffer := h.nd6CreateNeighborAdvertisement(target,i.SrcIP,false,true,false)
-			if buffer!=nil { sendchain.PushFront(buffer) }
+		/* Send Neighbor Advertisement. */
+		if nadv!=nil && nadvDelay!=0 {
+			h.sendDelayed(nadv,ethout,nadvDelay,po)
+		} else if nadv!=nil {
+			sendchain.PushFront(nadv)
 		}
 		
-		go h.send(sendchain,source_lla,po,layers.EthernetTypeIPv6)
+		go h.sendFrames(sendchain,&ethout,po)
 	}else{
 		ncache := h.NC6
-		nce := ncache.LookupValidOnly(target)
+		nce := ncache.LookupValidOnly(i.SrcIP)
 		if nce==nil { return /* Can't Send Neighbor Advertisements. (XXX) */ }
-		defer nce.RUnlock()
+		defer nce.Unlock()
 		
 		switch nce.State {
 		case ND6_NC__PHANTOM_,ND6_NC_INCOMPLETE:
 			return /* Can't Send Neighbor Advertisements. (XXX) */
 		}
 		
-		sendchain := list.New()
+		ethout := h.replyHeader(e,nce.HWAddr,layers.EthernetTypeIPv6)
 		
-		if target_is_local {
-			/* Send Neighbor Advertisement. */
-			buffer := h.nd6CreateNeighborAdvertisement(target,i.SrcIP,false,true,false)
-			if buffer!=nil { sendchain.PushFront(buffer) }
+		/* Send Neighbor Advertisement. */
+		if nadv!=nil && nadvDelay!=0 {
+			h.sendDelayed(nadv,ethout,nadvDelay,po)
+		} else if nadv!=nil {
+			sendchain := list.New()
+			sendchain.PushFront(nadv)
+			go h.sendFrames(sendchain,&ethout,po)
 		}
-		
-		go h.send(sendchain,nce.HWAddr,po,layers.EthernetTypeIPv6)
 	}
 	
 }
@@ -449,6 +493,19 @@ func (h *Host) nd6NeighborAdvertisement(i *ip.IPLayerPart, cm *layers.ICMPv6, po
 	 * advertisement.
 	 */
 	
+	/*
+	 * RFC4862 5.4.4:
+	 *   On receipt of a valid Neighbor Advertisement message on an
+	 *   interface, node behavior depends on whether the target address is
+	 *   tentative or matches a unicast or anycast address assigned to the
+	 *   interface. If the target address is tentative, the tentative
+	 *   address is not unique.
+	 */
+	if taentry,ok := h.Host.GetTarget6(target); ok && taentry.State==ip.ADDR_TENTATIVE {
+		h.Host.DADFailed(taentry)
+		return
+	}
+	
 	if len(target_lla)==0 { return }
 	
 	ncache := h.NC6
@@ -467,7 +524,10 @@ func (h *Host) nd6NeighborAdvertisement(i *ip.IPLayerPart, cm *layers.ICMPv6, po
 		return
 	}
 	defer nce.Unlock()
+	defer ncache.changed(nce,nce.shadow())
 	
+	/* Permanent entries MUST NOT be updated. */
+	if nce.State == ND6_NC_PERMANENT { return }
 	
 	was_router := nce.IsRouter
 	
@@ -586,8 +646,7 @@ func (h *Host) nd6NeighborAdvertisement(i *ip.IPLayerPart, cm *layers.ICMPv6, po
 		nce.RouterEntry.Remove()
 	}
 	
-	sendchain := nce.Sendchain
-	nce.Sendchain = list.New()
+	sendchain := ncache.take(&nce.Sendchain)
 	
 	/* Send 'sendchain' packets. */
 	go h.send(sendchain,target_lla,po,layers.EthernetTypeIPv6)
@@ -744,6 +803,7 @@ func (h *Host) nd6RouterAdvertisement(i *ip.IPLayerPart, cm *layers.ICMPv6, po P
 	ncache := h.NC6
 	nce := ncache.LookupOrCreate(i.SrcIP)
 	/* XXX We should be using ``defer nce.Unlock()'' here, but we need to unlock earlier. */
+	old := nce.shadow()
 	
 	/*
 	 * If the advertisement contains a Source Link-Layer Address
@@ -751,7 +811,10 @@ func (h *Host) nd6RouterAdvertisement(i *ip.IPLayerPart, cm *layers.ICMPv6, po P
 	 * Cache entry for the router (creating an entry if necessary) and the
 	 * IsRouter flag in the Neighbor Cache entry MUST be set to TRUE.
 	 */
-	if len(source_lla)>0 {
+	if nce.State == ND6_NC_PERMANENT {
+		/* Permanent entries keep their link-layer address. */
+		nce.IsRouter = true
+	}else if len(source_lla)>0 {
 		switch nce.State {
 		case ND6_NC_REACHABLE,ND6_NC_DELAY,ND6_NC_PROBE:
 			if !bytes.Equal(source_lla,nce.HWAddr) { break }
@@ -800,13 +863,15 @@ func (h *Host) nd6RouterAdvertisement(i *ip.IPLayerPart, cm *layers.ICMPv6, po P
 	}
 	
 	/* Unlock before processing prefixes. */
+	ncache.changed(nce,old)
 	nce.Unlock()
 	
-	h.Host.Lock(); defer h.Host.Unlock()
+	iid := eui64(h.Mac)
 	
 	/*
-	 * For each Prefix Information option with the on-link flag set, a host
-	 * does the following:
+	 * Each Prefix Information option updates the Prefix List (RFC4861 6.3.4,
+	 * for the on-link flag) and the addresses, autoconfigured from the prefix
+	 * (RFC4862 5.5.3, for the autonomous flag):
 	 */
 	for _,prefix := range prefixed {
 		/*
@@ -818,6 +883,14 @@ func (h *Host) nd6RouterAdvertisement(i *ip.IPLayerPart, cm *layers.ICMPv6, po P
 		var pfk ip.IPv6Prefix
 		
 		if prefix.PrefixLength>128 { continue }
+		
+		/*
+		 * RFC4862 5.5.3 (c):
+		 *   If the preferred lifetime is greater than the valid lifetime,
+		 *   silently ignore the Prefix Information option.
+		 */
+		if prefix.PreferredLifetime>prefix.ValidLifetime { continue }
+		
 		mask := net.CIDRMask(int(prefix.PrefixLength),128)
 		pfk.Len = prefix.PrefixLength
 		
@@ -825,56 +898,23 @@ func (h *Host) nd6RouterAdvertisement(i *ip.IPLayerPart, cm *layers.ICMPv6, po P
 			pfk.IP[i] = p & mask[i]
 		}
 		
-		//shouldAdd := false
-		//shouldRemove := false
-		
-		pe,ok := h.Host.Prefix6[pfk]
-		
-		if (!ok) && prefix.ValidLifetime!=0 {
-			/*
-			 * - If the prefix is not already present in the Prefix List, and the
-			 *   Prefix Information option's Valid Lifetime field is non-zero,
-			 *   create a new entry for the prefix and initialize its
-			 *   invalidation timer to the Valid Lifetime value in the Prefix
-			 *   Information option.
-			 */
-			pe = new(ip.IPv6PrefixEntry)
-			pe.List.Init()
-			pe.Prefix = pfk
-			pe.Lifetime = prefix.ValidLifetime
-			pe.Tstamp = NOW
-			pe.Onlink = (prefix.Flags&0x80)!=0
-			pe.Slaac  = (prefix.Flags&0x40)!=0
-			h.Host.Prefix6[pfk] = pe
-			//shouldAdd = true
-		} else if ok {
-			/*
-			 * - If the prefix is already present in the host's Prefix List as
-			 *   the result of a previously received advertisement, reset its
-			 *   invalidation timer to the Valid Lifetime value in the Prefix
-			 *   Information option.  If the new Lifetime value is zero, time-out
-			 *   the prefix immediately (see Section 6.3.5).
-			 */
-			
-			if prefix.ValidLifetime==0 {
-				/* If the new Lifetime value is zero, time-out the prefix immediately. */
-				delete(h.Host.Prefix6,pfk)
-				//shouldRemove = true
-			} else {
-				pe.Lifetime = prefix.ValidLifetime
-				pe.Tstamp = NOW
-			}
-		}
 		/*
-		 * - If the Prefix Information option's Valid Lifetime field is zero,
-		 *   and the prefix is not present in the host's Prefix List,
-		 *   silently ignore the option.
+		 * The Prefix List is updated and the addresses, autoconfigured from
+		 * the prefix, follow it's lifetimes (see ip.IPHost.PrefixInformation).
 		 */
-		
-		// TODO: Use Prefixes.
+		h.Host.PrefixInformation(pfk,prefix.ValidLifetime,prefix.PreferredLifetime,(prefix.Flags&0x80)!=0,(prefix.Flags&0x40)!=0,iid,NOW)
 	}
 }
 
+/*
+ * Returns the modified EUI-64 interface identifier of a 48 bit MAC address
+ * (RFC4291 Appendix A), or nil.
+ */
+func eui64(mac net.HardwareAddr) []byte {
+	if len(mac)!=6 { return nil }
+	return []byte{mac[0]^2,mac[1],mac[2],0xff,0xfe,mac[3],mac[4],mac[5]}
+}
+
 func (h *Host) nd6Redirect(i *ip.IPLayerPart, cm *layers.ICMPv6, po PacketOutput) {
 	/* TODO: Verify redirect message before processing. */
 	if len(cm.Payload) < 32 { return }
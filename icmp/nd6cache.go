@@ -64,6 +64,7 @@ import "github.com/maxymania/ipsolution/eth"
 const (
 	nDELAY_FIRST_PROBE_TIME = time.Second * 5
 	nRETRANS_TIMER = time.Second /* 1,000 milliseconds */
+	nMAX_ANYCAST_DELAY_TIME = time.Second
 	nMAX_UNICAST_SOLICIT = 3
 )
 
@@ -121,6 +122,15 @@ const (
 	ND6_NC_STALE
 	ND6_NC_DELAY
 	ND6_NC_PROBE
+	
+	/*
+	 * Administratively configured entry (not defined by RFC 4861).
+	 *
+	 * Permanent entries are never altered by received Neighbor Advertisements,
+	 * Solicitations or Router Advertisements, are not subject to Neighbor
+	 * Unreachability Detection and are never evicted from the cache.
+	 */
+	ND6_NC_PERMANENT
 )
 
 type IPv6Addr struct {
@@ -185,6 +195,8 @@ func (n *Nd6Nce) Init() *Nd6Nce {
 
 
 type Nd6Cache struct {
+	QueueLimits /* First, for the 64-bit alignment of it's counters. */
+	
 	Entries List
 	Routers List
 	
@@ -202,6 +214,8 @@ type Nd6Cache struct {
 	
 	redirect map[IPv6Addr]IPv6Addr
 	redirmtx sync.RWMutex
+	
+	events subscribers
 }
 func (n *Nd6Cache) Init() *Nd6Cache {
 	n.Entries.Init()
@@ -212,37 +226,56 @@ func (n *Nd6Cache) Init() *Nd6Cache {
 	n.Reachable.Init()
 	
 	n.Maxsize = 128000
+	n.initLimits()
 	n.Ipmap = make(map[IPv6Addr]*Nd6Nce)
 	n.redirect = make(map[IPv6Addr]IPv6Addr)
 	return n
 }
+// The *Nd6Nce must be locked. This methods calls nce.Unlock().
 func (n *Nd6Cache) removeEntry(nce *Nd6Nce) {
 	nce.Entry.Remove()
 	nce.RouterEntry.Remove()
 	nce.PlusEntry.Remove()
-	nce.Lock()
+	old := nce.shadow()
+	nce.State = ND6_NC__PHANTOM_
+	n.changed(nce,old)
+	nce.Unlock()
 	n.mutex.Lock(); defer n.mutex.Unlock();
 	if ptr,ok := n.Ipmap[nce.IPAddr]; ok && ptr==nce {
 		delete(n.Ipmap,nce.IPAddr)
 	}
 }
 func (n *Nd6Cache) LookupOrCreate(ip net.IP) *Nd6Nce {
-	n.mutex.Lock(); defer n.mutex.Unlock();
+	n.mutex.Lock()
 	sp := NewIPv6Addr(ip)
 	nce,ok := n.Ipmap[sp]
-	if ok { nce.Lock(); return nce }
+	if ok { nce.Lock(); n.mutex.Unlock(); return nce }
 	nce = new(Nd6Nce).Init()
 	nce.IPAddr = sp
 	n.Ipmap[sp] = nce
-	if n.Entries.Len()>n.Maxsize {
-		roe := n.Entries.Front()
-		if roe ==nil { goto done }
-		oe := roe.Value.(*Nd6Nce)
-		oe.Entry.Remove()
-		delete(n.Ipmap,oe.IPAddr)
+	
+	/*
+	 * The oldest entry is evicted, if the cache is full. The packets queued for
+	 * it are reported as undeliverable.
+	 */
+	var oe *Nd6Nce
+	if n.Entries.Len()>=n.Maxsize {
+		if roe := n.Entries.Front(); roe!=nil {
+			oe = roe.Value.(*Nd6Nce)
+			oe.Lock()
+			oe.Entry.Remove()
+			oe.RouterEntry.Remove()
+			oe.PlusEntry.Remove()
+			n.postpone(n.take(&oe.Sendchain))
+			delete(n.Ipmap,oe.IPAddr)
+			oe.Unlock()
+		}
 	}
-done:
 	n.Entries.PushBack(&nce.Entry)
+	n.mutex.Unlock()
+	
+	/* The evicted entry is locked for the event, while no lock is held. */
+	if oe!=nil { n.evicted(oe) }
 	nce.Lock()
 	return nce
 }
@@ -263,6 +296,39 @@ func (n *Nd6Cache) LookupValidOnly(ip net.IP) *Nd6Nce {
 	nce.Lock()
 	return nce
 }
+/*
+ * Adds or replaces a permanent entry. Packets, that were queued awaiting
+ * address resolution are returned and must be sent by the caller.
+ */
+func (n *Nd6Cache) AddPermanent(ip net.IP, hw net.HardwareAddr, isRouter bool) (sendchain *list.List) {
+	nce := n.LookupOrCreate(ip)
+	defer nce.Unlock()
+	defer n.changed(nce,nce.shadow())
+	nce.State = ND6_NC_PERMANENT
+	nce.HWAddr = hw
+	nce.IsRouter = isRouter
+	nce.Tstamp = time.Now()
+	
+	/* Permanent entries are neither evicted nor subject to any timer. */
+	nce.Entry.Remove()
+	nce.PlusEntry.Remove()
+	if isRouter {
+		n.Routers.PushBack(&nce.RouterEntry)
+	}else{
+		nce.RouterEntry.Remove()
+	}
+	
+	sendchain = n.take(&nce.Sendchain)
+	return
+}
+// Removes a permanent entry. Returns false if no permanent entry exists.
+func (n *Nd6Cache) RemovePermanent(ip net.IP) bool {
+	nce := n.Lookup(ip)
+	if nce==nil { return false }
+	if nce.State != ND6_NC_PERMANENT { nce.Unlock(); return false }
+	n.removeEntry(nce) /* This methods calls nce.Unlock() */
+	return true
+}
 func (n *Nd6Cache) Redirect(i *IPv6Addr){
 	n.redirmtx.RLock(); defer n.redirmtx.RUnlock()
 	ii := *i
@@ -322,6 +388,8 @@ func (n *Nd6Cache) SelectRouter() *IPv6Addr {
 }
 
 func (n *Nd6Cache) TimerEvent(h *Host, e *eth.EthLayer2,po PacketOutput, NOW time.Time) {
+	if failed := n.takePostponed(); failed!=nil { h.resolutionFailed(failed,po) }
+	
 	/* Process NCEs in the DELAY-state */
 	for {
 		le := n.Delay.Front()
@@ -329,6 +397,7 @@ func (n *Nd6Cache) TimerEvent(h *Host, e *eth.EthLayer2,po PacketOutput, NOW tim
 		nce := le.Value.(*Nd6Nce)
 		nce.Lock()
 		if time.Since(nce.Tstamp) < nDELAY_FIRST_PROBE_TIME { nce.Unlock(); break }
+		old := nce.shadow()
 		nce.State = ND6_NC_PROBE
 		nce.SolicitationSendCounter = 0
 		nce.Tstamp = NOW
@@ -337,6 +406,7 @@ func (n *Nd6Cache) TimerEvent(h *Host, e *eth.EthLayer2,po PacketOutput, NOW tim
 		nce.PlusEntry.Remove()
 		
 		n.Retrans.PushBack(&nce.PlusEntry)
+		n.changed(nce,old)
 		nce.Unlock()
 	}
 	
@@ -349,7 +419,18 @@ func (n *Nd6Cache) TimerEvent(h *Host, e *eth.EthLayer2,po PacketOutput, NOW tim
 		if time.Since(nce.Tstamp) < nRETRANS_TIMER { nce.Unlock(); break }
 		nce.SolicitationSendCounter++
 		if nce.SolicitationSendCounter >= nMAX_UNICAST_SOLICIT {
+			/*
+			 * RFC-4861 7.2.2:
+			 *   If no Neighbor Advertisement is received after
+			 *   MAX_MULTICAST_SOLICIT solicitations, address resolution
+			 *   has failed.  The sender MUST return ICMP destination
+			 *   unreachable indications with code 3 (Address
+			 *   Unreachable) for each packet queued awaiting address
+			 *   resolution.
+			 */
+			failed := n.take(&nce.Sendchain)
 			n.removeEntry(nce) /* This methods calls nce.Unlock() */
+			h.resolutionFailed(failed,po)
 			continue
 		}
 		switch nce.State {
@@ -365,11 +446,15 @@ func (n *Nd6Cache) TimerEvent(h *Host, e *eth.EthLayer2,po PacketOutput, NOW tim
 		    }
 		    */
 		case ND6_NC_INCOMPLETE,ND6_NC_PROBE: {
-			srcI := net.IP(nce.LocalIPAddr.Array[:])
 			dstI := net.IP(nce.IPAddr.Array[:])
+			/* Entries, created by received ND messages, have no LocalIPAddr. */
+			if ipis0(nce.LocalIPAddr.Array[:]) {
+				if srcI := h.Host.SelectSource(dstI); srcI!=nil { nce.LocalIPAddr = NewIPv6Addr(srcI) }
+			}
+			srcI := net.IP(nce.LocalIPAddr.Array[:])
 			solp,hwaddr := h.nd6CreateNeighborSolicitation(srcI,nil,dstI) /* AR */
 			e.DstMAC = hwaddr
-			if e.SerializeTo(solp,gopacket.SerializeOptions{true,true})!=nil {
+			if solp!=nil && e.SerializeTo(solp,gopacket.SerializeOptions{true,true})==nil {
 				po.WritePacketData(solp.Bytes())
 			}
 		    }
@@ -398,9 +483,11 @@ func (n *Nd6Cache) TimerEvent(h *Host, e *eth.EthLayer2,po PacketOutput, NOW tim
 		nce.Lock()
 		if time.Since(nce.Tstamp) < reachableTime { nce.Unlock(); break }
 		
+		old := nce.shadow()
 		nce.State = ND6_NC_STALE
 		nce.Tstamp = NOW
 		nce.Entry.MoveToBack()
+		n.changed(nce,old)
 		nce.Unlock()
 	}
 }
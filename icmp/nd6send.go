@@ -24,7 +24,6 @@ SOFTWARE.
 
 package icmp
 
-import "github.com/maxymania/ipsolution/eth"
 import "github.com/google/gopacket/layers"
 import "github.com/google/gopacket"
 import "net"
@@ -62,20 +61,32 @@ func (h *Host) ResolutionV6(l *list.List, srcIP, destIP net.IP, po PacketOutput)
 		
 		nce := ncache.LookupOrCreate(dip.Array[:])
 		defer nce.Unlock()
+		defer ncache.changed(nce,nce.shadow())
 		
 		switch nce.State {
 		case ND6_NC__PHANTOM_:
+			/*
+			 * RFC4861 7.2.2:
+			 *   If the source address of the packet prompting the solicitation
+			 *   is the same as one of the addresses assigned to the outgoing
+			 *   interface, that address SHOULD be placed in the IP Source
+			 *   Address of the outgoing solicitation.  Otherwise, any one of
+			 *   the addresses assigned to the interface should be used.
+			 *
+			 * The address is kept for retransmissions.
+			 */
+			if srcIP==nil || !h.Host.IsUnicast(srcIP) { srcIP = h.Host.SelectSource(dip.Array[:]) }
+			if srcIP==nil { return ENoSource }
+			solp,hwa := h.nd6CreateNeighborSolicitation(srcIP,nil /* for AR */,dip.Array[:])
+			if solp==nil { return ENoSource }
+			
 			nce.State = ND6_NC_INCOMPLETE
 			nce.Tstamp = time.Now()
+			nce.LocalIPAddr = NewIPv6Addr(srcIP)
 			nce.Entry.MoveToBack()
-			solp,hwa := h.nd6CreateNeighborSolicitation(srcIP,nil /* for AR */,destIP)
 			
 			{
-				var e eth.EthLayer2
-				e.SrcMAC = h.Mac
-				e.VLANIdentifier = h.Vlan
-				e.DstMAC = hwa
-				e.EthernetType = layers.EthernetTypeIPv6
+				e := h.ethHeader(hwa,layers.EthernetTypeIPv6,DSCP_CS6)
 				err := e.SerializeTo(solp,gopacket.SerializeOptions{true,true})
 				if err!=nil { return err }
 				po.WritePacketData(solp.Bytes())
@@ -85,7 +96,11 @@ func (h *Host) ResolutionV6(l *list.List, srcIP, destIP net.IP, po PacketOutput)
 			
 			fallthrough
 		case ND6_NC_INCOMPLETE:
-			nce.Sendchain.PushBackList(l)
+			/*
+			 * Packets, dropped by the queue limits, are reported like those of
+			 * a failed resolution, once the entry is unlocked.
+			 */
+			if dropped := ncache.enqueue(nce.Sendchain,l); dropped!=nil { go h.resolutionFailed(dropped,po) }
 			return nil
 		case ND6_NC_STALE:
 			/*
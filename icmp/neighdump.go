@@ -0,0 +1,204 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package icmp
+
+import "encoding/json"
+import "bytes"
+import "sort"
+import "net"
+import "time"
+import "fmt"
+import "io"
+
+func (s ARP_STATE) String() string {
+	switch s {
+	case ARP__PHANTOM_: return "NONE"
+	case ARP_INCOMPLETE: return "INCOMPLETE"
+	case ARP_COMPLETE: return "REACHABLE"
+	case ARP_PERMANENT: return "PERMANENT"
+	}
+	return fmt.Sprintf("ARP_STATE(%d)",uint8(s))
+}
+
+func (s ND6_NC_STATE) String() string {
+	switch s {
+	case ND6_NC__PHANTOM_: return "NONE"
+	case ND6_NC_INCOMPLETE: return "INCOMPLETE"
+	case ND6_NC_REACHABLE: return "REACHABLE"
+	case ND6_NC_STALE: return "STALE"
+	case ND6_NC_DELAY: return "DELAY"
+	case ND6_NC_PROBE: return "PROBE"
+	case ND6_NC_PERMANENT: return "PERMANENT"
+	}
+	return fmt.Sprintf("ND6_NC_STATE(%d)",uint8(s))
+}
+
+/*
+ * A copy of a neighbor cache entry, as returned by the Snapshot methods.
+ */
+type NeighborInfo struct{
+	IPAddr net.IP
+	HWAddr net.HardwareAddr
+	Dev    string
+	State  string
+	Age    time.Duration /* Time since the last state change. */
+	IsRouter bool
+	Probes int /* Unanswered solicitations. */
+	Queued int /* Packets awaiting address resolution. */
+}
+
+func (n *NeighborInfo) write(w io.Writer, stats bool) {
+	fmt.Fprint(w,n.IPAddr)
+	if n.Dev!="" { fmt.Fprint(w," dev ",n.Dev) }
+	if len(n.HWAddr)!=0 { fmt.Fprint(w," lladdr ",n.HWAddr) }
+	if n.IsRouter { fmt.Fprint(w," router") }
+	if stats {
+		fmt.Fprintf(w," used %d probes %d queued %d",int64(n.Age/time.Second),n.Probes,n.Queued)
+	}
+	fmt.Fprint(w," ",n.State)
+}
+
+// Formats the entry like a line of ``ip neigh show''.
+func (n NeighborInfo) String() string {
+	buf := new(bytes.Buffer)
+	n.write(buf,false)
+	return buf.String()
+}
+
+// Encodes the entry like ``ip -j neigh show'' does.
+func (n NeighborInfo) MarshalJSON() ([]byte, error) {
+	var obj struct{
+		Dst string `json:"dst"`
+		Dev string `json:"dev,omitempty"`
+		Lladdr string `json:"lladdr,omitempty"`
+		Router bool `json:"router,omitempty"`
+		Used int64 `json:"used"`
+		Probes int `json:"probes"`
+		Queued int `json:"queued"`
+		State []string `json:"state"`
+	}
+	obj.Dst = n.IPAddr.String()
+	obj.Dev = n.Dev
+	if len(n.HWAddr)!=0 { obj.Lladdr = n.HWAddr.String() }
+	obj.Router = n.IsRouter
+	obj.Used = int64(n.Age/time.Second)
+	obj.Probes = n.Probes
+	obj.Queued = n.Queued
+	obj.State = []string{n.State}
+	return json.Marshal(&obj)
+}
+
+/*
+ * Writes the entries in the format of ``ip neigh show'', one per line. If
+ * stats is true, the output resembles ``ip -s neigh show''.
+ */
+func WriteNeighbors(w io.Writer, list []NeighborInfo, stats bool) error {
+	buf := new(bytes.Buffer)
+	for i := range list {
+		list[i].write(buf,stats)
+		buf.WriteByte('\n')
+	}
+	_,err := w.Write(buf.Bytes())
+	return err
+}
+
+func sortNeighbors(list []NeighborInfo) {
+	sort.Slice(list,func(i,j int) bool {
+		return bytes.Compare(list[i].IPAddr,list[j].IPAddr)<0
+	})
+}
+
+/*
+ * Returns a copy of all entries in the cache. Each entry is copied while
+ * being locked.
+ */
+func (n *ArpCache) Snapshot() []NeighborInfo {
+	n.mutex.RLock()
+	entries := make([]*ArpCe,0,len(n.Ipmap))
+	for _,ce := range n.Ipmap { entries = append(entries,ce) }
+	n.mutex.RUnlock()
+	
+	NOW := time.Now()
+	list := make([]NeighborInfo,0,len(entries))
+	for _,ce := range entries {
+		ce.RLock()
+		if ce.State!=ARP__PHANTOM_ {
+			ni := NeighborInfo{
+				IPAddr: ce.IPAddr.IP(),
+				HWAddr: copymac(ce.HWAddr),
+				State: ce.State.String(),
+				Age: NOW.Sub(ce.Tstamp),
+				Probes: ce.SolicitationSendCounter,
+				Queued: ce.Sendchain.Len(),
+			}
+			/* ARP has no STALE state, but aged entries are in fact stale. */
+			if ce.State==ARP_COMPLETE && ni.Age > n.Timeout { ni.State = "STALE" }
+			list = append(list,ni)
+		}
+		ce.RUnlock()
+	}
+	sortNeighbors(list)
+	return list
+}
+
+/*
+ * Returns a copy of all entries in the cache. Each entry is copied while
+ * being locked.
+ */
+func (n *Nd6Cache) Snapshot() []NeighborInfo {
+	n.mutex.RLock()
+	entries := make([]*Nd6Nce,0,len(n.Ipmap))
+	for _,nce := range n.Ipmap { entries = append(entries,nce) }
+	n.mutex.RUnlock()
+	
+	NOW := time.Now()
+	list := make([]NeighborInfo,0,len(entries))
+	for _,nce := range entries {
+		nce.RLock()
+		if nce.State!=ND6_NC__PHANTOM_ {
+			list = append(list,NeighborInfo{
+				IPAddr: net.IP(copydat(nce.IPAddr.Array[:])),
+				HWAddr: copymac(nce.HWAddr),
+				State: nce.State.String(),
+				Age: NOW.Sub(nce.Tstamp),
+				IsRouter: nce.IsRouter,
+				Probes: nce.SolicitationSendCounter,
+				Queued: nce.Sendchain.Len(),
+			})
+		}
+		nce.RUnlock()
+	}
+	sortNeighbors(list)
+	return list
+}
+
+// Returns the IPv4 and IPv6 neighbors of this host.
+func (h *Host) Neighbors() []NeighborInfo {
+	var list []NeighborInfo
+	if h.ARP!=nil { list = append(list,h.ARP.Snapshot()...) }
+	if h.NC6!=nil { list = append(list,h.NC6.Snapshot()...) }
+	for i := range list { list[i].Dev = h.Name }
+	return list
+}
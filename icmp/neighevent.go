@@ -0,0 +1,182 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package icmp
+
+import "bytes"
+import "net"
+import "sync"
+
+/*
+ * Neighbor state change events.
+ *
+ * Subscribers of the ARP cache receive *ArpEvent values, subscribers of the
+ * ND6 cache receive *Nd6Event values through their Notify method.
+ *
+ * Notify is called synchronously, while the cache entry is locked. It must not
+ * block and must not call back into the cache, except for Subscribe and
+ * Unsubscribe.
+ */
+type NEIGH_EVENT uint8
+const (
+	NEIGH_CREATED = NEIGH_EVENT(1<<iota) /* The entry came into existence. */
+	NEIGH_STATE    /* The state changed. */
+	NEIGH_LLADDR   /* The link-layer address changed. */
+	NEIGH_ROUTER   /* The IsRouter flag changed (ND6 only). */
+	NEIGH_DELETED  /* The entry has been removed. */
+)
+
+func (e NEIGH_EVENT) Has(o NEIGH_EVENT) bool { return (e&o)!=0 }
+
+type ArpEvent struct{
+	Event NEIGH_EVENT
+	IPAddr net.IP
+	HWAddr, OldHWAddr net.HardwareAddr
+	State, OldState ARP_STATE
+}
+
+type Nd6Event struct{
+	Event NEIGH_EVENT
+	IPAddr net.IP
+	HWAddr, OldHWAddr net.HardwareAddr
+	State, OldState ND6_NC_STATE
+	IsRouter, WasRouter bool
+}
+
+type subscribers struct{
+	mutex sync.RWMutex
+	list []Notifyable
+}
+func (s *subscribers) subscribe(n Notifyable) {
+	s.mutex.Lock(); defer s.mutex.Unlock()
+	s.list = append(s.list,n)
+}
+func (s *subscribers) unsubscribe(n Notifyable) {
+	s.mutex.Lock(); defer s.mutex.Unlock()
+	for i,o := range s.list {
+		if o!=n { continue }
+		s.list = append(s.list[:i:i],s.list[i+1:]...)
+		return
+	}
+}
+/*
+Calls the subscribers from a copy of the list, so that they may call Subscribe
+or Unsubscribe from their Notify method.
+*/
+func (s *subscribers) notify(i interface{}) {
+	s.mutex.RLock()
+	list := append([]Notifyable(nil),s.list...)
+	s.mutex.RUnlock()
+	for _,n := range list { n.Notify(i) }
+}
+func (s *subscribers) empty() bool {
+	s.mutex.RLock(); defer s.mutex.RUnlock()
+	return len(s.list)==0
+}
+
+func neighEvent(oldPh, newPh, stateEq, hwEq bool) (ev NEIGH_EVENT) {
+	switch {
+	case oldPh && newPh: return 0
+	case oldPh: return NEIGH_CREATED
+	case newPh: return NEIGH_DELETED
+	}
+	if !stateEq { ev |= NEIGH_STATE }
+	if !hwEq { ev |= NEIGH_LLADDR }
+	return
+}
+
+/* ------------------------------ARP-Part--------------------------------- */
+
+type arpShadow struct{
+	State ARP_STATE
+	HWAddr net.HardwareAddr
+}
+func (a *ArpCe) shadow() arpShadow { return arpShadow{a.State,a.HWAddr} }
+
+func (n *ArpCache) Subscribe(s Notifyable) { n.events.subscribe(s) }
+func (n *ArpCache) Unsubscribe(s Notifyable) { n.events.unsubscribe(s) }
+
+// Reports changes of the (locked) entry since the shadow 'old' was taken.
+func (n *ArpCache) changed(a *ArpCe, old arpShadow) {
+	ev := neighEvent(
+		old.State==ARP__PHANTOM_,
+		a.State==ARP__PHANTOM_,
+		old.State==a.State,
+		bytes.Equal(old.HWAddr,a.HWAddr))
+	if ev==0 || n.events.empty() { return }
+	n.events.notify(&ArpEvent{ev,a.IPAddr.IP(),a.HWAddr,old.HWAddr,a.State,old.State})
+}
+
+/*
+ * Reports the eviction of an entry. It is locked to take the shadow, so the
+ * caller must not hold any lock of the cache or it's entries.
+ */
+func (n *ArpCache) evicted(a *ArpCe) {
+	a.Lock(); old := a.shadow(); a.Unlock()
+	if old.State==ARP__PHANTOM_ || n.events.empty() { return }
+	n.events.notify(&ArpEvent{NEIGH_DELETED,a.IPAddr.IP(),nil,old.HWAddr,ARP__PHANTOM_,old.State})
+}
+
+/* ------------------------------ND6-Part--------------------------------- */
+
+type nd6Shadow struct{
+	State ND6_NC_STATE
+	HWAddr net.HardwareAddr
+	IsRouter bool
+}
+func (n *Nd6Nce) shadow() nd6Shadow { return nd6Shadow{n.State,n.HWAddr,n.IsRouter} }
+
+func (n *Nd6Cache) Subscribe(s Notifyable) { n.events.subscribe(s) }
+func (n *Nd6Cache) Unsubscribe(s Notifyable) { n.events.unsubscribe(s) }
+
+// Reports changes of the (locked) entry since the shadow 'old' was taken.
+func (n *Nd6Cache) changed(nce *Nd6Nce, old nd6Shadow) {
+	ev := neighEvent(
+		old.State==ND6_NC__PHANTOM_,
+		nce.State==ND6_NC__PHANTOM_,
+		old.State==nce.State,
+		bytes.Equal(old.HWAddr,nce.HWAddr))
+	if old.IsRouter!=nce.IsRouter && old.State!=ND6_NC__PHANTOM_ && nce.State!=ND6_NC__PHANTOM_ {
+		ev |= NEIGH_ROUTER
+	}
+	if ev==0 || n.events.empty() { return }
+	n.events.notify(&Nd6Event{ev,
+		net.IP(copydat(nce.IPAddr.Array[:])),
+		nce.HWAddr,old.HWAddr,
+		nce.State,old.State,
+		nce.IsRouter,old.IsRouter})
+}
+/*
+ * Reports the eviction of an entry. It is locked to take the shadow, so the
+ * caller must not hold any lock of the cache or it's entries.
+ */
+func (n *Nd6Cache) evicted(nce *Nd6Nce) {
+	nce.Lock(); old := nce.shadow(); nce.Unlock()
+	if old.State==ND6_NC__PHANTOM_ || n.events.empty() { return }
+	n.events.notify(&Nd6Event{NEIGH_DELETED,
+		net.IP(copydat(nce.IPAddr.Array[:])),
+		nil,old.HWAddr,
+		ND6_NC__PHANTOM_,old.State,
+		false,old.IsRouter})
+}
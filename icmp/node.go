@@ -0,0 +1,229 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package icmp
+
+import "github.com/maxymania/ipsolution/ip"
+import "github.com/maxymania/ipsolution/eth"
+import "github.com/google/gopacket"
+import "github.com/google/gopacket/layers"
+
+import "sort"
+import "sync"
+import "time"
+import "fmt"
+import "net"
+import "strconv"
+
+var EExists = fmt.Errorf("Interface exists")
+var ENoIface = fmt.Errorf("No such interface")
+var EZoneRequired = fmt.Errorf("Link-local address requires a zone")
+
+/*
+ * Initializes the neighbor caches and the address list of an interface, if
+ * they are not set.
+ */
+func (h *Host) Init() *Host {
+	if h.NC6==nil { h.NC6 = new(Nd6Cache).Init() }
+	if h.ARP==nil { h.ARP = new(ArpCache).Init() }
+	if h.Host==nil { h.Host = new(ip.IPHost).Init() }
+	h.Host.Zone = h.Name
+	return h
+}
+
+/*
+ * Runs the timers of the neighbor caches, the address lifetimes and Duplicate
+ * Address Detection. The packets are sent through Output.
+ */
+func (h *Host) TimerEvent(NOW time.Time) {
+	if h.Host!=nil { h.Host.TimerEvent(NOW) }
+	if h.Output==nil { return }
+	if h.ARP!=nil { h.ARP.TimerEvent(h,h.Output,NOW) }
+	if h.NC6!=nil {
+		e := h.ethHeader(nil,layers.EthernetTypeIPv6,DSCP_CS6)
+		h.NC6.TimerEvent(h,&e,h.Output,NOW)
+	}
+	if h.Host!=nil { h.dadTimer(NOW,h.Output) }
+}
+
+/*
+ * RFC4862 5.4.2:
+ *   To check an address, a node SHOULD send a total of
+ *   DupAddrDetectTransmits Neighbor Solicitations, each separated by
+ *   RetransTimer milliseconds.
+ */
+func (h *Host) dadTimer(NOW time.Time, po PacketOutput) {
+	retrans := nRETRANS_TIMER
+	if h.RetransTimer!=0 { retrans = time.Duration(h.RetransTimer)*time.Millisecond }
+	transmits := h.DupAddrDetectTransmits
+	if transmits==0 { transmits = 1 }
+	if transmits<0 { transmits = 0 }
+	for _,target := range h.Host.DADProbe(NOW,retrans,transmits) {
+		solp,hwa := h.nd6CreateNeighborSolicitation(nil /* DAD */,nil,target)
+		if solp==nil { continue }
+		e := h.ethHeader(hwa,layers.EthernetTypeIPv6,DSCP_CS6)
+		if e.SerializeTo(solp,gopacket.SerializeOptions{true,true})!=nil { continue }
+		po.WritePacketData(solp.Bytes())
+	}
+}
+
+/*
+ * A Node is a (possibly multi-homed) host, consisting of one or more
+ * interfaces. Each interface is represented by a *Host, which has it's own
+ * neighbor caches, address and prefix lists and ND parameters.
+ *
+ * Interfaces are identified by Index (matching gopacket.CaptureInfo's
+ * InterfaceIndex) and by Name, which is also the zone of it's link-local
+ * addresses, as in "fe80::1%tap1".
+ */
+type Node struct{
+	byIndex map[int]*Host
+	byName  map[string]*Host
+	
+	mutex sync.RWMutex
+}
+func (n *Node) Init() *Node {
+	n.byIndex = make(map[int]*Host)
+	n.byName = make(map[string]*Host)
+	return n
+}
+func (n *Node) AddInterface(h *Host) error {
+	n.mutex.Lock(); defer n.mutex.Unlock()
+	if _,ok := n.byIndex[h.Index]; ok { return EExists }
+	if _,ok := n.byName[h.Name]; ok && h.Name!="" { return EExists }
+	h.Init()
+	n.byIndex[h.Index] = h
+	if h.Name!="" { n.byName[h.Name] = h }
+	return nil
+}
+func (n *Node) RemoveInterface(h *Host) {
+	n.mutex.Lock(); defer n.mutex.Unlock()
+	if n.byIndex[h.Index]==h { delete(n.byIndex,h.Index) }
+	if n.byName[h.Name]==h { delete(n.byName,h.Name) }
+}
+func (n *Node) Interface(index int) *Host {
+	n.mutex.RLock(); defer n.mutex.RUnlock()
+	return n.byIndex[index]
+}
+func (n *Node) InterfaceByName(name string) *Host {
+	n.mutex.RLock(); defer n.mutex.RUnlock()
+	return n.byName[name]
+}
+// Returns all interfaces, ordered by index.
+func (n *Node) Interfaces() []*Host {
+	n.mutex.RLock()
+	hs := make([]*Host,0,len(n.byIndex))
+	for _,h := range n.byIndex { hs = append(hs,h) }
+	n.mutex.RUnlock()
+	sort.Slice(hs,func(i,j int) bool { return hs[i].Index<hs[j].Index })
+	return hs
+}
+
+/*
+ * Returns the interface a zone refers to. A zone is eighter an interface name
+ * or a numeric interface index.
+ */
+func (n *Node) Zone(zone string) *Host {
+	if h := n.InterfaceByName(zone); h!=nil { return h }
+	if idx,err := strconv.Atoi(zone); err==nil { return n.Interface(idx) }
+	return nil
+}
+
+/*
+ * Returns the interface, packets to the given address are sent through.
+ *
+ * Link-local addresses must carry a zone, unless the node has only one
+ * interface. Other addresses are routed to the interface, they are on-link
+ * on, or to an interface with a default router.
+ */
+func (n *Node) Route(addr *net.IPAddr) (*Host, error) {
+	if addr.Zone!="" {
+		h := n.Zone(addr.Zone)
+		if h==nil { return nil,ENoIface }
+		return h,nil
+	}
+	hs := n.Interfaces()
+	if len(hs)==0 { return nil,ENoIface }
+	if len(hs)==1 { return hs[0],nil }
+	if ip.IsLinkLocal(addr.IP) { return nil,EZoneRequired }
+	if i4 := addr.IP.To4(); i4!=nil {
+		for _,h := range hs {
+			if h.Host.IsOnLink(i4) { return h,nil }
+		}
+		for _,h := range hs {
+			if h.Host.SelectSource(i4)!=nil { return h,nil }
+		}
+		return nil,ENoGateway
+	}
+	for _,h := range hs {
+		if h.Host.IsOnLink(addr.IP) { return h,nil }
+	}
+	for _,h := range hs {
+		if h.NC6.Routers.Len()>0 { return h,nil }
+	}
+	return nil,ENoGateway
+}
+
+/*
+ * Parses an address with an optional zone (like "fe80::1%tap1") and returns
+ * the interface it is reached through.
+ */
+func (n *Node) ParseAddr(s string) (*Host, *net.IPAddr, error) {
+	addr,err := ip.ParseZoned(s)
+	if err!=nil { return nil,nil,err }
+	h,err := n.Route(addr)
+	return h,addr,err
+}
+
+/*
+ * Dispatches a received packet to the interface, it was received on, as
+ * indicated by ci.InterfaceIndex.
+ */
+func (n *Node) Input(ci gopacket.CaptureInfo, e *eth.EthLayer2, i *ip.IPLayerPart, po PacketOutput) error {
+	h := n.Interface(ci.InterfaceIndex)
+	if h==nil { return ENoIface }
+	return h.Input(e,i,po)
+}
+
+/*
+ * Dispatches a received frame to the interface, it was received on, as
+ * indicated by ci.InterfaceIndex (see Host.InputFrame).
+ */
+func (n *Node) InputFrame(ci gopacket.CaptureInfo, e *eth.EthLayer2, po PacketOutput) error {
+	h := n.Interface(ci.InterfaceIndex)
+	if h==nil { return ENoIface }
+	return h.InputFrame(e,po)
+}
+
+// Runs the timers of all interfaces.
+func (n *Node) TimerEvent(NOW time.Time) {
+	for _,h := range n.Interfaces() { h.TimerEvent(NOW) }
+}
+
+// Returns the neighbors of all interfaces.
+func (n *Node) Neighbors() []NeighborInfo {
+	var list []NeighborInfo
+	for _,h := range n.Interfaces() { list = append(list,h.Neighbors()...) }
+	return list
+}
@@ -0,0 +1,263 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package icmp
+
+import "github.com/maxymania/ipsolution/ip"
+import "github.com/maxymania/ipsolution/eth"
+import "github.com/google/gopacket"
+import "github.com/google/gopacket/layers"
+
+import "container/list"
+import "encoding/binary"
+import "sync/atomic"
+import "fmt"
+import "net"
+
+var EMsgSize = fmt.Errorf("Message too long")
+
+/* Default IPv4 TTL and IPv6 Hop Limit (RFC1700, RFC4861 6.3.2). */
+const DefaultTTL = 64
+
+/*
+ * Options of Host.Send. The zero value (or nil) selects the defaults.
+ */
+type SendOptions struct{
+	/* Source address. If nil, it is selected by the Host. */
+	Src net.IP
+	
+	/* TTL or Hop Limit. If 0, Host.TTL or Host.CurHopLimit is used. */
+	TTL uint8
+	
+	/* TOS or Traffic Class (DSCP<<2|ECN) and the IPv6 Flow Label. */
+	TOS uint8
+	FlowLabel uint32
+	
+	/*
+	 * Fail with EMsgSize instead of fragmenting. For IPv4, the DF bit is set
+	 * (IP_DONTFRAG / IPV6_DONTFRAG).
+	 */
+	DontFragment bool
+	
+	/*
+	 * Send the packet to the sender of ReplyTo, with it's VLAN tags, instead
+	 * of resolving the next hop.
+	 */
+	ReplyTo *eth.EthLayer2
+}
+
+var defaultSendOptions SendOptions
+
+/*
+ * A stack of layers, serialized as payload of an IP packet. The first layer
+ * is the outermost one (the transport header).
+ */
+type Stack []gopacket.SerializableLayer
+
+/* Returns the type of the outermost layer (gopacket.LayerTypePayload, if empty). */
+func (s Stack) LayerType() gopacket.LayerType {
+	if len(s)==0 { return gopacket.LayerTypePayload }
+	if l,ok := s[0].(gopacket.Layer); ok { return l.LayerType() }
+	return gopacket.LayerTypePayload
+}
+func (s Stack) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	for i := len(s)-1; i>=0; i-- {
+		if err := s[i].SerializeTo(b,opts); err!=nil { return err }
+	}
+	return nil
+}
+
+type checksumLayer interface{
+	SetNetworkLayerForChecksum(l gopacket.NetworkLayer) error
+}
+
+/* Sets the pseudo header for the checksums of ICMPv6, UDP and TCP. */
+func setChecksumLayer(payload gopacket.SerializableLayer, nl gopacket.NetworkLayer) {
+	switch p := payload.(type) {
+	case Stack:
+		for _,l := range p { setChecksumLayer(l,nl) }
+	case checksumLayer:
+		p.SetNetworkLayerForChecksum(nl)
+	}
+}
+
+func (h *Host) mtu4() int {
+	if h.MTU==0 { return 1500 }
+	return int(h.MTU)
+}
+func (h *Host) mtu6() int {
+	m := h.mtu4()
+	if h.IPv6MTU!=0 && int(h.IPv6MTU)<m { m = int(h.IPv6MTU) }
+	return m
+}
+
+/*
+ * Sends an IP packet through Output. The IP version is that of dst. The
+ * packet is fragmented, if it exceeds the MTU (MTU or IPv6MTU), and passed
+ * to address resolution.
+ *
+ * Transport layers, that need a pseudo header checksum (like
+ * layers.ICMPv6, layers.UDP or layers.TCP), get it set automatically.
+ */
+func (h *Host) Send(dst net.IP, proto layers.IPProtocol, payload gopacket.SerializableLayer, opts *SendOptions) error {
+	if h.Output==nil { return ENoIface }
+	return h.sendIP(dst,proto,payload,opts,h.Output)
+}
+
+func (h *Host) sendIP(dst net.IP, proto layers.IPProtocol, payload gopacket.SerializableLayer, opts *SendOptions, po PacketOutput) error {
+	if opts==nil { opts = &defaultSendOptions }
+	l,src,err := h.buildIP(dst,proto,payload,opts)
+	if err!=nil { return err }
+	return h.outputIP(l,src,dst,opts,po)
+}
+
+/*
+ * RFC1122 3.2.2.6 / RFC4443 4.2:
+ *   The source address of the reply MUST be a unicast address belonging to
+ *   the node. If the request was sent to a multicast (or broadcast) address,
+ *   the source is selected, as for any other packet.
+ */
+func (h *Host) echoOptions(e *eth.EthLayer2, i *ip.IPLayerPart) *SendOptions {
+	o := &SendOptions{ReplyTo:e}
+	if h.Host.IsUnicast(i.DstIP) { o.Src = i.DstIP }
+	return o
+}
+
+/* Passes IP packets to address resolution or sends them as a reply. */
+func (h *Host) outputIP(l *list.List, src, dst net.IP, opts *SendOptions, po PacketOutput) error {
+	etype := layers.EthernetTypeIPv6
+	if dst.To4()!=nil { etype = layers.EthernetTypeIPv4 }
+	if opts!=nil && opts.ReplyTo!=nil {
+		e := h.replyHeader(opts.ReplyTo,opts.ReplyTo.SrcMAC,etype)
+		h.sendFrames(l,&e,po)
+		return nil
+	}
+	if etype==layers.EthernetTypeIPv4 { return h.ResolutionV4(l,src.To4(),dst.To4(),po) }
+	return h.ResolutionV6(l,src,dst,po)
+}
+
+/*
+ * Builds the IP packet (or it's fragments) as a list of SerializeBuffers.
+ */
+func (h *Host) buildIP(dst net.IP, proto layers.IPProtocol, payload gopacket.SerializableLayer, opts *SendOptions) (l *list.List, src net.IP, err error) {
+	src = opts.Src
+	if src==nil { src = h.Host.SelectSource(dst) }
+	if src==nil { return nil,nil,ENoSource }
+	
+	var i4 layers.IPv4
+	var i6 layers.IPv6
+	var nl gopacket.NetworkLayer
+	v4 := dst.To4()!=nil
+	if v4 {
+		i4.Version = 4
+		i4.TTL = opts.TTL
+		if i4.TTL==0 { i4.TTL = h.TTL }
+		if i4.TTL==0 { i4.TTL = DefaultTTL }
+		i4.TOS = opts.TOS
+		i4.Protocol = proto
+		i4.SrcIP = src.To4()
+		i4.DstIP = dst.To4()
+		if opts.DontFragment { i4.Flags = layers.IPv4DontFragment }
+		nl = &i4
+	} else {
+		i6.Version = 6
+		i6.HopLimit = opts.TTL
+		if i6.HopLimit==0 { i6.HopLimit = h.CurHopLimit }
+		if i6.HopLimit==0 { i6.HopLimit = DefaultTTL }
+		i6.TrafficClass = opts.TOS
+		i6.FlowLabel = opts.FlowLabel
+		i6.NextHeader = proto
+		i6.SrcIP = src.To16()
+		i6.DstIP = dst.To16()
+		nl = &i6
+	}
+	setChecksumLayer(payload,nl)
+	
+	op := gopacket.SerializeOptions{true,true}
+	pb := gopacket.NewSerializeBuffer()
+	if err = payload.SerializeTo(pb,op); err!=nil { return }
+	data := pb.Bytes()
+	
+	l = list.New()
+	if v4 {
+		mtu := h.mtu4()
+		if 20+len(data)<=mtu {
+			err = h.appendPacket(l,op,&i4,data)
+			return
+		}
+		if opts.DontFragment { return nil,nil,EMsgSize }
+		
+		/* RFC791 3.2: Fragments carry a multiple of 8 octets, except the last one. */
+		i4.Id = uint16(atomic.AddUint32(&h.ipid,1))
+		chunk := (mtu-20)&^7
+		for off := 0; off<len(data); off += chunk {
+			end := off+chunk
+			i4.Flags = layers.IPv4MoreFragments
+			if end>=len(data) { end,i4.Flags = len(data),0 }
+			i4.FragOffset = uint16(off>>3)
+			if err = h.appendPacket(l,op,&i4,data[off:end]); err!=nil { return }
+		}
+		return
+	}
+	mtu := h.mtu6()
+	if 40+len(data)<=mtu {
+		err = h.appendPacket(l,op,&i6,data)
+		return
+	}
+	if opts.DontFragment { return nil,nil,EMsgSize }
+	
+	/* RFC8200 4.5: Fragment Header */
+	id := atomic.AddUint32(&h.ipid,1)
+	i6.NextHeader = layers.IPProtocolIPv6Fragment
+	chunk := (mtu-48)&^7
+	for off := 0; off<len(data); off += chunk {
+		end := off+chunk
+		more := uint16(1)
+		if end>=len(data) { end,more = len(data),0 }
+		fb := make([]byte,8,8+end-off)
+		fb[0] = byte(proto)
+		binary.BigEndian.PutUint16(fb[2:],uint16(off)|more)
+		binary.BigEndian.PutUint32(fb[4:],id)
+		if err = h.appendPacket(l,op,&i6,append(fb,data[off:end]...)); err!=nil { return }
+	}
+	return
+}
+
+/* Builds a single, unfragmented packet. Returns nil on failure. */
+func (h *Host) packetIP(dst net.IP, proto layers.IPProtocol, payload gopacket.SerializableLayer, opts *SendOptions) gopacket.SerializeBuffer {
+	o := *opts
+	o.DontFragment = true
+	l,_,err := h.buildIP(dst,proto,payload,&o)
+	if err!=nil { return nil }
+	return l.Front().Value.(gopacket.SerializeBuffer)
+}
+
+func (h *Host) appendPacket(l *list.List, op gopacket.SerializeOptions, nl gopacket.SerializableLayer, data []byte) error {
+	/* Leave room for the Ethernet header and two VLAN tags. */
+	SB := gopacket.NewSerializeBufferExpectedSize(len(data)+64,0)
+	err := gopacket.SerializeLayers(SB,op,nl,gopacket.Payload(data))
+	if err!=nil { return err }
+	l.PushBack(SB)
+	return nil
+}
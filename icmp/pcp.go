@@ -0,0 +1,84 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package icmp
+
+import "container/list"
+import "github.com/google/gopacket"
+import "github.com/maxymania/ipsolution/eth"
+
+/*
+ * DSCP of Network Control traffic (RFC4594 3.2: CS6). ARP and Neighbor
+ * Discovery are sent with this class.
+ */
+const DSCP_CS6 = 48
+
+/*
+ * Maps a DSCP (0-63) to an 802.1p priority (PCP, 0-7) for frames originated
+ * by a Host. Replies keep the priority of the request instead.
+ */
+type PCPMap [64]uint8
+
+/*
+ * Returns a PCPMap, that maps every DSCP to the priority of it's class
+ * selector (the upper three bits), so CS6 (48) maps to PCP 6 and EF (46) to
+ * PCP 5.
+ */
+func ClassSelectorPCPMap() *PCPMap {
+	m := new(PCPMap)
+	for i := range m { m[i] = uint8(i>>3) }
+	return m
+}
+
+/* Returns the PCP of a DSCP. A nil map gives PCP 0 for everything. */
+func (m *PCPMap) Lookup(dscp uint8) uint8 {
+	if m==nil { return 0 }
+	return m[dscp&63]&7
+}
+
+/*
+ * Returns the DSCP of an IPv4 or IPv6 packet, held in a SerializeBuffer
+ * or []byte.
+ */
+func dscpOf(v interface{}) uint8 {
+	var b []byte
+	switch ev := v.(type) {
+	case gopacket.SerializeBuffer: b = ev.Bytes()
+	case []byte: b = ev
+	}
+	if len(b)<2 { return 0 }
+	switch b[0]>>4 {
+	case 4: return b[1]>>2
+	case 6: return ((b[0]<<4)|(b[1]>>4))>>2
+	}
+	return 0
+}
+
+func (h *Host) sendClassified(l *list.List, e *eth.EthLayer2, po PacketOutput) {
+	op := gopacket.SerializeOptions{true,true}
+	for elem := l.Front(); elem!=nil; elem = elem.Next() {
+		e.Priority = h.PCP.Lookup(dscpOf(elem.Value))
+		h.sendFrame(elem.Value,e,op,po)
+	}
+}
@@ -0,0 +1,109 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package icmp
+
+import "github.com/maxymania/ipsolution/ip"
+import "github.com/maxymania/ipsolution/eth"
+import "github.com/google/gopacket/layers"
+
+import "sync"
+import "fmt"
+
+var ENotForUs = fmt.Errorf("Packet not addressed to us")
+var EUnreachable = fmt.Errorf("Protocol unreachable")
+
+/*
+ * Receives the IP packets of a protocol, registered with a Host's Protocols.
+ * i.Payload is the upper layer payload (after IPv6 extension headers).
+ */
+type ProtocolHandler interface{
+	InputIP(e *eth.EthLayer2, i *ip.IPLayerPart, po PacketOutput) error
+}
+
+type ProtocolHandlerFunc func(e *eth.EthLayer2, i *ip.IPLayerPart, po PacketOutput) error
+func (f ProtocolHandlerFunc) InputIP(e *eth.EthLayer2, i *ip.IPLayerPart, po PacketOutput) error {
+	return f(e,i,po)
+}
+
+/*
+ * A table of IP protocol handlers (transports such as UDP, TCP or SCTP, and
+ * raw protocols such as GRE or OSPF). A handler receives the packets of both
+ * IPv4 and IPv6. ICMPv4 and ICMPv6 are handled by the Host itself.
+ */
+type ProtocolRegistry struct{
+	handlers map[layers.IPProtocol]ProtocolHandler
+	mutex sync.RWMutex
+}
+func (r *ProtocolRegistry) Register(p layers.IPProtocol, hd ProtocolHandler) error {
+	switch p {
+	case layers.IPProtocolICMPv4,layers.IPProtocolICMPv6: return EReservedType
+	}
+	r.mutex.Lock(); defer r.mutex.Unlock()
+	if _,ok := r.handlers[p]; ok { return EHandlerExists }
+	if r.handlers==nil { r.handlers = make(map[layers.IPProtocol]ProtocolHandler) }
+	r.handlers[p] = hd
+	return nil
+}
+func (r *ProtocolRegistry) Unregister(p layers.IPProtocol) {
+	r.mutex.Lock(); defer r.mutex.Unlock()
+	delete(r.handlers,p)
+}
+func (r *ProtocolRegistry) Lookup(p layers.IPProtocol) ProtocolHandler {
+	r.mutex.RLock(); defer r.mutex.RUnlock()
+	return r.handlers[p]
+}
+
+/*
+ * Dispatches an IP packet, addressed to us, to it's protocol handler.
+ *
+ * If there is no handler, an ICMP Destination Unreachable (Protocol
+ * Unreachable) or an ICMPv6 Parameter Problem (unrecognized Next Header) is
+ * sent, as of RFC1122 3.2.2.1 and RFC4443 3.4, unless a RawSocket received the
+ * packet. No error is sent for packets
+ * to broadcast or multicast addresses (RFC1122 3.2.2, RFC4443 2.4 (e)).
+ */
+func (h *Host) inputProtocol(e *eth.EthLayer2, i *ip.IPLayerPart, po PacketOutput, raw bool) error {
+	if !h.Host.Input(i.DstIP) { return ENotForUs }
+	if hd := h.Protocols.Lookup(i.Protocol); hd!=nil {
+		return hd.InputIP(e,i,po)
+	}
+	/* The packet was received by a RawSocket. */
+	if raw { return nil }
+	if !h.Host.IsUnicast(i.DstIP) { return EUnreachable }
+	/* RFC8200 4.7: No Next Header */
+	if i.Protocol==layers.IPProtocolNoNextHeader { return nil }
+	/* Fragments are not reassembled, so only the first one would be reported. */
+	if !i.IsV6 && (i.V4.Flags&layers.IPv4MoreFragments!=0 || i.V4.FragOffset!=0) { return EUnreachable }
+	if i.IsV6 {
+		pkt := append(append([]byte(nil),i.V6.Contents...),i.V6.Payload...)
+		code := layers.CreateICMPv6TypeCode(layers.ICMPv6TypeParameterProblem,layers.ICMPv6CodeUnrecognizedNextHeader)
+		h.icmpError6(pkt,code,uint32(i.NextHeaderOffset),po)
+	} else {
+		pkt := append(append([]byte(nil),i.V4.Contents...),i.V4.Payload...)
+		code := layers.CreateICMPv4TypeCode(layers.ICMPv4TypeDestinationUnreachable,layers.ICMPv4CodeProtocol)
+		h.icmpError4(pkt,code,po)
+	}
+	return EUnreachable
+}
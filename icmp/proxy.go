@@ -0,0 +1,146 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package icmp
+
+import "net"
+import "sync"
+
+/*
+ * Proxy-ARP and Proxy-ND (RFC 4389).
+ *
+ * A ProxyTable holds the addresses and prefixes, this host answers ARP
+ * requests and Neighbor Solicitations for, on behalf of other nodes.
+ * Entries can be added and removed at any time.
+ */
+
+type proxyPrefix4 struct{
+	Addr, Mask IPv4Addr
+}
+type proxyPrefix6 struct{
+	Addr IPv6Addr
+	Len uint8
+}
+
+type ProxyTable struct{
+	addr4 map[IPv4Addr]bool
+	addr6 map[IPv6Addr]bool
+	prefix4 map[proxyPrefix4]bool
+	prefix6 map[proxyPrefix6]bool
+	
+	mutex sync.RWMutex
+}
+func (p *ProxyTable) Init() *ProxyTable {
+	p.addr4 = make(map[IPv4Addr]bool)
+	p.addr6 = make(map[IPv6Addr]bool)
+	p.prefix4 = make(map[proxyPrefix4]bool)
+	p.prefix6 = make(map[proxyPrefix6]bool)
+	return p
+}
+func maskIPv6(a IPv6Addr, plen uint8) (r IPv6Addr) {
+	mask := net.CIDRMask(int(plen),128)
+	for i,b := range a.Array { r.Array[i] = b & mask[i] }
+	return
+}
+func (p *ProxyTable) AddAddr(ip net.IP) {
+	p.mutex.Lock(); defer p.mutex.Unlock()
+	if i4 := ip.To4(); i4!=nil {
+		p.addr4[NewIPv4Addr(i4)] = true
+	} else if len(ip)==16 {
+		p.addr6[NewIPv6Addr(ip)] = true
+	}
+}
+func (p *ProxyTable) RemoveAddr(ip net.IP) {
+	p.mutex.Lock(); defer p.mutex.Unlock()
+	if i4 := ip.To4(); i4!=nil {
+		delete(p.addr4,NewIPv4Addr(i4))
+	} else if len(ip)==16 {
+		delete(p.addr6,NewIPv6Addr(ip))
+	}
+}
+func prefixKeys(n *net.IPNet) (k4 *proxyPrefix4, k6 *proxyPrefix6) {
+	ones,bits := n.Mask.Size()
+	switch bits {
+	case 32:
+		m := NewIPv4Addr(net.IP(n.Mask))
+		k4 = &proxyPrefix4{NewIPv4Addr(n.IP)&m,m}
+	case 128:
+		k6 = &proxyPrefix6{maskIPv6(NewIPv6Addr(n.IP),uint8(ones)),uint8(ones)}
+	}
+	return
+}
+func (p *ProxyTable) AddPrefix(n *net.IPNet) {
+	k4,k6 := prefixKeys(n)
+	p.mutex.Lock(); defer p.mutex.Unlock()
+	if k4!=nil { p.prefix4[*k4] = true }
+	if k6!=nil { p.prefix6[*k6] = true }
+}
+func (p *ProxyTable) RemovePrefix(n *net.IPNet) {
+	k4,k6 := prefixKeys(n)
+	p.mutex.Lock(); defer p.mutex.Unlock()
+	if k4!=nil { delete(p.prefix4,*k4) }
+	if k6!=nil { delete(p.prefix6,*k6) }
+}
+
+/* Reports, whether there are IPv6 entries, whose solicited-node groups are to be received. */
+func (p *ProxyTable) hasV6() bool {
+	if p==nil { return false }
+	p.mutex.RLock(); defer p.mutex.RUnlock()
+	return len(p.addr6)!=0 || len(p.prefix6)!=0
+}
+
+// Returns true, if the given address should be answered for by proxy.
+func (p *ProxyTable) Match(ip net.IP) bool {
+	if p==nil { return false }
+	p.mutex.RLock(); defer p.mutex.RUnlock()
+	if i4 := ip.To4(); i4!=nil {
+		a := NewIPv4Addr(i4)
+		if p.addr4[a] { return true }
+		for px := range p.prefix4 {
+			if a&px.Mask == px.Addr { return true }
+		}
+		return false
+	}
+	if len(ip)!=16 { return false }
+	a := NewIPv6Addr(ip)
+	if p.addr6[a] { return true }
+	for px := range p.prefix6 {
+		if maskIPv6(a,px.Len)==px.Addr { return true }
+	}
+	return false
+}
+
+// Lists the proxied addresses and prefixes.
+func (p *ProxyTable) List() (addrs []net.IP, prefixes []*net.IPNet) {
+	p.mutex.RLock(); defer p.mutex.RUnlock()
+	for a := range p.addr4 { addrs = append(addrs,a.IP()) }
+	for a := range p.addr6 { addrs = append(addrs,net.IP(copydat(a.Array[:]))) }
+	for px := range p.prefix4 {
+		prefixes = append(prefixes,&net.IPNet{px.Addr.IP(),net.IPMask(px.Mask.IP())})
+	}
+	for px := range p.prefix6 {
+		prefixes = append(prefixes,&net.IPNet{net.IP(copydat(px.Addr.Array[:])),net.CIDRMask(int(px.Len),128)})
+	}
+	return
+}
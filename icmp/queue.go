@@ -0,0 +1,155 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package icmp
+
+import "github.com/google/gopacket"
+import "container/list"
+import "sync/atomic"
+import "sync"
+
+/*
+ * Limits for packets queued awaiting address resolution.
+ *
+ * If a limit is exceeded, the oldest packets are dropped. As the queues of
+ * the other neighbors are locked by their entries, the packets are dropped
+ * from the queue of the neighbor, a packet is queued for; if a global limit
+ * is exceeded and this queue holds no older packets, the new packet itself
+ * is dropped. Dropped packets are reported like packets of a failed address
+ * resolution (ICMP Destination Unreachable). A limit of 0 means unlimited.
+ */
+type QueueLimits struct{
+	/* Updated atomically, kept first for 64-bit alignment. */
+	queued, queuedBytes int64
+	drops uint64
+	
+	/* Per neighbor limits. */
+	QueueLen, QueueBytes int
+	
+	/* Limits for all neighbors of a cache. */
+	GlobalQueueLen, GlobalQueueBytes int
+	
+	/* Queues of evicted entries, reported at the next TimerEvent. */
+	postponed *list.List
+	pmutex sync.Mutex
+}
+func (q *QueueLimits) initLimits() {
+	q.QueueLen = 101
+	q.QueueBytes = 212992
+	q.GlobalQueueLen = 4096
+	q.GlobalQueueBytes = 4<<20
+}
+
+// Returns the number of packets dropped due to queue overflows.
+func (q *QueueLimits) Drops() uint64 { return atomic.LoadUint64(&q.drops) }
+
+// Returns the number and size of all packets queued.
+func (q *QueueLimits) Queued() (packets, bytes int) {
+	return int(atomic.LoadInt64(&q.queued)),int(atomic.LoadInt64(&q.queuedBytes))
+}
+
+func pktlen(i interface{}) int {
+	switch v := i.(type) {
+	case gopacket.SerializeBuffer: return len(v.Bytes())
+	case []byte: return len(v)
+	}
+	return 0
+}
+
+func (q *QueueLimits) overflow(plen,pbytes int) bool {
+	if q.QueueLen>0 && plen>q.QueueLen { return true }
+	if q.QueueBytes>0 && pbytes>q.QueueBytes { return true }
+	return false
+}
+
+func (q *QueueLimits) globalOverflow() bool {
+	if q.GlobalQueueLen>0 && atomic.LoadInt64(&q.queued)>int64(q.GlobalQueueLen) { return true }
+	if q.GlobalQueueBytes>0 && atomic.LoadInt64(&q.queuedBytes)>int64(q.GlobalQueueBytes) { return true }
+	return false
+}
+
+/*
+ * Appends the packets in 'l' to the queue 'chain'. Then the oldest packets of
+ * 'chain' are dropped as long as a limit is exceeded. Returns the dropped
+ * packets, or nil.
+ */
+func (q *QueueLimits) enqueue(chain, l *list.List) (dropped *list.List) {
+	pbytes := 0
+	for e := chain.Front(); e!=nil; e = e.Next() { pbytes += pktlen(e.Value) }
+	for e := l.Front(); e!=nil; e = e.Next() {
+		n := pktlen(e.Value)
+		chain.PushBack(e.Value)
+		pbytes += n
+		atomic.AddInt64(&q.queued,1)
+		atomic.AddInt64(&q.queuedBytes,int64(n))
+	}
+	for chain.Len()>0 && (q.overflow(chain.Len(),pbytes) || q.globalOverflow()) {
+		v := chain.Remove(chain.Front())
+		n := pktlen(v)
+		pbytes -= n
+		atomic.AddInt64(&q.queued,-1)
+		atomic.AddInt64(&q.queuedBytes,-int64(n))
+		if dropped==nil { dropped = list.New() }
+		dropped.PushBack(v)
+		atomic.AddUint64(&q.drops,1)
+	}
+	return
+}
+
+/*
+ * Accounts for the packets in 'chain' leaving the queue (either being sent or
+ * dropped).
+ */
+func (q *QueueLimits) dequeue(chain *list.List) {
+	n := 0
+	for e := chain.Front(); e!=nil; e = e.Next() { n += pktlen(e.Value) }
+	atomic.AddInt64(&q.queued,-int64(chain.Len()))
+	atomic.AddInt64(&q.queuedBytes,-int64(n))
+}
+
+// Detaches the queue of a locked entry.
+func (q *QueueLimits) take(chain **list.List) *list.List {
+	l := *chain
+	*chain = list.New()
+	q.dequeue(l)
+	return l
+}
+
+/*
+ * Stores the detached queue of an evicted entry, until it is reported by the
+ * cache's TimerEvent (the cache has no Host to report it to).
+ */
+func (q *QueueLimits) postpone(l *list.List) {
+	if l.Len()==0 { return }
+	q.pmutex.Lock(); defer q.pmutex.Unlock()
+	if q.postponed==nil { q.postponed = list.New() }
+	q.postponed.PushBackList(l)
+}
+
+// Returns the queues stored with postpone, or nil.
+func (q *QueueLimits) takePostponed() (l *list.List) {
+	q.pmutex.Lock(); defer q.pmutex.Unlock()
+	l,q.postponed = q.postponed,nil
+	return
+}
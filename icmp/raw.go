@@ -0,0 +1,168 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package icmp
+
+import "github.com/maxymania/ipsolution/ip"
+import "github.com/google/gopacket"
+import "github.com/google/gopacket/layers"
+
+import "container/list"
+import "sync"
+import "fmt"
+import "net"
+
+var EClosed = fmt.Errorf("Socket closed")
+var ENoSource = fmt.Errorf("No source address")
+
+/* Number of packets, a RawSocket queues for reception. */
+const RawQueueLen = 64
+
+/*
+ * A packet, received by a RawSocket.
+ */
+type RawPacket struct{
+	SrcIP, DstIP net.IP
+	Protocol layers.IPProtocol
+	TTL uint8 /* TTL or Hop Limit */
+	TOS uint8 /* TOS or Traffic Class */
+	
+	/* The IP header (including IPv6 extension headers) and the payload. */
+	Header []byte
+	Payload []byte
+}
+
+/*
+ * A raw IP endpoint for an address family and a protocol number, opened with
+ * Host.OpenRaw. It receives copies of all packets of it's protocol, addressed
+ * to the Host (including ICMP), in addition to the protocol's handler. If there
+ * is no handler, but a RawSocket, no Protocol Unreachable is sent.
+ *
+ * Send prepends an IP header, unless HdrIncl is set (IP_HDRINCL), in which
+ * case the payload must be a complete IP packet.
+ */
+type RawSocket struct{
+	V6 bool
+	Protocol layers.IPProtocol
+	HdrIncl bool
+	
+	host *Host
+	recv chan *RawPacket
+	once sync.Once
+}
+
+type rawTable struct{
+	socks []*RawSocket
+	mutex sync.RWMutex
+}
+
+/* Opens a raw IP endpoint. */
+func (h *Host) OpenRaw(v6 bool, proto layers.IPProtocol) *RawSocket {
+	r := &RawSocket{V6:v6,Protocol:proto,host:h,recv:make(chan *RawPacket,RawQueueLen)}
+	h.raw.mutex.Lock(); defer h.raw.mutex.Unlock()
+	h.raw.socks = append(h.raw.socks,r)
+	return r
+}
+
+/* Closes the endpoint. Recv returns EClosed afterwards. */
+func (r *RawSocket) Close() {
+	t := &r.host.raw
+	t.mutex.Lock(); defer t.mutex.Unlock()
+	for i,s := range t.socks {
+		if s!=r { continue }
+		t.socks = append(t.socks[:i],t.socks[i+1:]...)
+		break
+	}
+	r.once.Do(func() { close(r.recv) })
+}
+
+/* Returns the channel, received packets are delivered to. */
+func (r *RawSocket) C() <-chan *RawPacket { return r.recv }
+
+/* Waits for a packet. */
+func (r *RawSocket) Recv() (*RawPacket,error) {
+	p,ok := <-r.recv
+	if !ok { return nil,EClosed }
+	return p,nil
+}
+
+/* Copies a received packet, for a RawSocket. */
+func newRawPacket(i *ip.IPLayerPart) *RawPacket {
+	p := &RawPacket{SrcIP:copyip(i.SrcIP),DstIP:copyip(i.DstIP),Protocol:i.Protocol,Payload:copydat(i.Payload)}
+	if i.IsV6 {
+		p.TTL,p.TOS = i.V6.HopLimit,i.V6.TrafficClass
+		p.Header = copydat(i.V6.Contents)
+		if len(i.ES6.Contents)!=0 { p.Header = append(p.Header,i.ES6.Contents...) }
+	} else {
+		p.TTL,p.TOS = i.V4.TTL,i.V4.TOS
+		p.Header = copydat(i.V4.Contents)
+	}
+	return p
+}
+
+/*
+ * Delivers copies of a packet to the matching RawSockets, each socket gets it's
+ * own copy. Reports, whether any socket matched.
+ */
+func (t *rawTable) deliver(i *ip.IPLayerPart) (matched bool) {
+	t.mutex.RLock(); defer t.mutex.RUnlock()
+	for _,r := range t.socks {
+		if r.V6!=i.IsV6 || r.Protocol!=i.Protocol { continue }
+		matched = true
+		/* Like a socket's receive buffer, excess packets are dropped. */
+		select {
+		case r.recv <- newRawPacket(i):
+		default:
+		}
+	}
+	return
+}
+
+/*
+ * Sends a packet to dst through Host.Send, with the default options. In
+ * HdrIncl mode, payload is sent as is (unfragmented), dst is only used for
+ * the next hop.
+ */
+func (r *RawSocket) SendTo(dst net.IP, payload []byte) error {
+	h := r.host
+	po := h.Output
+	if po==nil { return ENoIface }
+	if r.V6 != (dst.To4()==nil) { return EInvalid }
+	if !r.HdrIncl {
+		return h.sendIP(dst,r.Protocol,gopacket.Payload(payload),nil,po)
+	}
+	var src net.IP
+	switch {
+	case r.V6 && len(payload)>=40: src = net.IP(copydat(payload[8:24]))
+	case !r.V6 && len(payload)>=20: src = net.IP(copydat(payload[12:16]))
+	default: return EInvalid
+	}
+	SB := gopacket.NewSerializeBufferExpectedSize(64,0)
+	b,err := SB.AppendBytes(len(payload))
+	if err!=nil { return err }
+	copy(b,payload)
+	l := list.New()
+	l.PushBack(SB)
+	return h.outputIP(l,src,dst,nil,po)
+}
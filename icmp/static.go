@@ -0,0 +1,50 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package icmp
+
+import "github.com/google/gopacket/layers"
+import "net"
+
+/*
+ * Adds a permanent (static) neighbor entry, for either IPv4 or IPv6.
+ * Packets, that were waiting for the resolution of this address are sent.
+ */
+func (h *Host) AddPermanentNeighbor(addr net.IP, hw net.HardwareAddr, isRouter bool, po PacketOutput) {
+	if i4 := addr.To4(); i4!=nil {
+		sendchain := h.ARP.AddPermanent(i4,hw)
+		h.send(sendchain,hw,po,layers.EthernetTypeIPv4)
+	} else {
+		sendchain := h.NC6.AddPermanent(addr,hw,isRouter)
+		h.send(sendchain,hw,po,layers.EthernetTypeIPv6)
+	}
+}
+
+// Removes a permanent (static) neighbor entry.
+func (h *Host) RemovePermanentNeighbor(addr net.IP) bool {
+	if i4 := addr.To4(); i4!=nil {
+		return h.ARP.RemovePermanent(i4)
+	}
+	return h.NC6.RemovePermanent(addr)
+}
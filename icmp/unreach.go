@@ -0,0 +1,154 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package icmp
+
+import "github.com/google/gopacket"
+import "github.com/google/gopacket/layers"
+import "container/list"
+import "net"
+
+/*
+ * Handles the packets, that were queued awaiting a failed address resolution.
+ *
+ * RFC-4861 7.2.2:
+ *   The sender MUST return ICMP destination unreachable indications with
+ *   code 3 (Address Unreachable) for each packet queued awaiting address
+ *   resolution.
+ *
+ * For locally originated packets, the indication is delivered to NetN/NetNv6,
+ * otherwise an ICMP error is sent to the source of the packet.
+ */
+func (h *Host) resolutionFailed(l *list.List, po PacketOutput) {
+	for elem := l.Front(); elem!=nil; elem = elem.Next() {
+		var pkt []byte
+		switch ev := elem.Value.(type) {
+		case gopacket.SerializeBuffer: pkt = ev.Bytes()
+		case []byte: pkt = ev
+		}
+		if len(pkt)==0 { continue }
+		switch pkt[0]>>4 {
+		case 4: h.unreachable4(pkt,po)
+		case 6: h.unreachable6(pkt,po)
+		}
+	}
+}
+
+func (h *Host) unreachable4(pkt []byte, po PacketOutput) {
+	var i4 layers.IPv4
+	if i4.DecodeFromBytes(pkt,gopacket.NilDecodeFeedback)!=nil { return }
+	code := layers.CreateICMPv4TypeCode(layers.ICMPv4TypeDestinationUnreachable,layers.ICMPv4CodeHost)
+	
+	if h.Host.Input(i4.SrcIP) {
+		if h.NetN==nil { return }
+		h.NetN.Notify(&IPUnreachable{code,copyip(i4.DstIP)})
+		return
+	}
+	h.icmpError4(pkt,code,po)
+}
+
+/*
+ * Sends an ICMP error message about the IPv4 packet pkt to it's source.
+ */
+func (h *Host) icmpError4(pkt []byte, code layers.ICMPv4TypeCode, po PacketOutput) {
+	var i4 layers.IPv4
+	if i4.DecodeFromBytes(pkt,gopacket.NilDecodeFeedback)!=nil { return }
+	
+	/*
+	 * RFC-1812 4.3.2.7:
+	 *   A router MUST NOT originate an ICMP error message in response to
+	 *   another ICMP error message, a packet destined to a broadcast or
+	 *   multicast address, or a packet with a non-unique source address.
+	 */
+	if ipis0(i4.SrcIP) || i4.SrcIP.IsMulticast() || i4.SrcIP.Equal(net.IPv4bcast) { return }
+	if i4.Protocol==layers.IPProtocolICMPv4 && len(i4.Payload)>0 {
+		switch layers.ICMPv4TypeCode(uint16(i4.Payload[0])<<8).Type() {
+		case layers.ICMPv4TypeEchoRequest,layers.ICMPv4TypeEchoReply,
+			layers.ICMPv4TypeTimestampRequest,layers.ICMPv4TypeTimestampReply,
+			layers.ICMPv4TypeInfoRequest,layers.ICMPv4TypeInfoReply:
+		default: return
+		}
+	}
+	src := h.Host.SelectSource(i4.SrcIP)
+	if src==nil { return }
+	
+	/* Internet Header + 64 bits of Original Data Datagram */
+	lng := len(i4.Contents)+8
+	if lng>len(pkt) { lng = len(pkt) }
+	
+	var icmp layers.ICMPv4
+	icmp.TypeCode = code
+	
+	h.sendIP(copyip(i4.SrcIP),layers.IPProtocolICMPv4,Stack{&icmp,gopacket.Payload(copydat(pkt[:lng]))},&SendOptions{Src:src},po)
+}
+
+func (h *Host) unreachable6(pkt []byte, po PacketOutput) {
+	var i6 layers.IPv6
+	if i6.DecodeFromBytes(pkt,gopacket.NilDecodeFeedback)!=nil { return }
+	code := layers.CreateICMPv6TypeCode(layers.ICMPv6TypeDestinationUnreachable,layers.ICMPv6CodeAddressUnreachable)
+	
+	if _,local := h.Host.GetTarget6(i6.SrcIP); local {
+		if h.NetNv6!=nil {
+			h.NetNv6.Notify(IP6Unreachable{code,copyip(i6.DstIP)})
+		}else  if h.NetN!=nil {
+			h.NetN.Notify(&IPUnreachable{duV6ToV4(code),copyip(i6.DstIP)})
+		}
+		return
+	}
+	h.icmpError6(pkt,code,0,po)
+}
+
+/*
+ * Sends an ICMPv6 error message about the IPv6 packet pkt to it's source.
+ * The 32 bit field following the checksum is set to param (the Pointer of a
+ * Parameter Problem or the MTU of a Packet Too Big message).
+ */
+func (h *Host) icmpError6(pkt []byte, code layers.ICMPv6TypeCode, param uint32, po PacketOutput) {
+	var i6 layers.IPv6
+	if i6.DecodeFromBytes(pkt,gopacket.NilDecodeFeedback)!=nil { return }
+	
+	/*
+	 * RFC-4443 2.4. (e):
+	 *   An ICMPv6 error message MUST NOT be originated as a result of
+	 *   receiving an ICMPv6 error message, or a packet whose source address
+	 *   does not uniquely identify a single node.
+	 */
+	if ipis0(i6.SrcIP) || i6.SrcIP[0]==0xff { return }
+	if i6.NextHeader==layers.IPProtocolICMPv6 && len(i6.Payload)>0 && i6.Payload[0]<128 { return }
+	src := h.Host.SelectSource(i6.SrcIP)
+	if src==nil { return }
+	
+	/*
+	 * As much of invoking packet as possible without the ICMPv6 packet
+	 * exceeding the minimum IPv6 MTU.
+	 */
+	lng := len(pkt)
+	if lng > 1280-48 { lng = 1280-48 }
+	
+	var icmp layers.ICMPv6
+	icmp.TypeCode = code
+	icmp.TypeBytes = []byte{byte(param>>24),byte(param>>16),byte(param>>8),byte(param)}
+	
+	h.sendIP(copyip(i6.SrcIP),layers.IPProtocolICMPv6,Stack{&icmp,gopacket.Payload(copydat(pkt[:lng]))},&SendOptions{Src:src},po)
+}
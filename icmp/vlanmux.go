@@ -0,0 +1,140 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package icmp
+
+import "github.com/maxymania/ipsolution/ip"
+import "github.com/maxymania/ipsolution/eth"
+
+import "sort"
+import "sync"
+import "time"
+import "fmt"
+
+var ENoVlan = fmt.Errorf("No such VLAN")
+
+/*
+ * Consumes decoded frames. Implemented by *Host and *VlanMux.
+ */
+type FrameInput interface{
+	Input(e *eth.EthLayer2, i *ip.IPLayerPart, po PacketOutput) error
+}
+
+/*
+ * A VlanMux dispatches the frames of a trunk interface by VLAN-ID to one
+ * *Host per VLAN, so that a single TAP device can serve many isolated L3
+ * segments, each one with it's own addresses and neighbor caches.
+ *
+ * Frames are dispatched by their innermost VID (the C-VID). A frame is only
+ * accepted by a Host, if it's S-VID matches Host.SVlan. Untagged and
+ * priority-tagged (VID 0) frames are passed to Native. If Native is nil, they
+ * are dropped.
+ */
+type VlanMux struct{
+	Native FrameInput
+	
+	vlans map[uint16]*Host
+	mutex sync.RWMutex
+}
+func (m *VlanMux) Init() *VlanMux {
+	m.vlans = make(map[uint16]*Host)
+	return m
+}
+
+/*
+ * Adds a VLAN sub-interface. The Host's Vlan is set to vid, and it is
+ * initialized with Host.Init(). It's Output should be that of the trunk.
+ */
+func (m *VlanMux) AddVlan(vid uint16, h *Host) error {
+	if vid==0 || vid>=4095 { return ENoVlan }
+	m.mutex.Lock(); defer m.mutex.Unlock()
+	if _,ok := m.vlans[vid]; ok { return EExists }
+	h.Vlan = vid
+	h.Init()
+	m.vlans[vid] = h
+	return nil
+}
+func (m *VlanMux) RemoveVlan(vid uint16) *Host {
+	m.mutex.Lock(); defer m.mutex.Unlock()
+	h := m.vlans[vid]
+	delete(m.vlans,vid)
+	return h
+}
+func (m *VlanMux) Vlan(vid uint16) *Host {
+	m.mutex.RLock(); defer m.mutex.RUnlock()
+	return m.vlans[vid]
+}
+
+/* Returns the VLAN sub-interfaces, ordered by VID. */
+func (m *VlanMux) Vlans() []*Host {
+	m.mutex.RLock()
+	hs := make([]*Host,0,len(m.vlans))
+	for _,h := range m.vlans { hs = append(hs,h) }
+	m.mutex.RUnlock()
+	sort.Slice(hs,func(i,j int) bool { return hs[i].Vlan<hs[j].Vlan })
+	return hs
+}
+
+func (m *VlanMux) Input(e *eth.EthLayer2, i *ip.IPLayerPart, po PacketOutput) error {
+	if e.VLANIdentifier==0 {
+		if m.Native==nil { return ENoVlan }
+		return m.Native.Input(e,i,po)
+	}
+	h := m.Vlan(e.VLANIdentifier)
+	if h==nil || h.SVlan!=e.SVLANIdentifier { return ENoVlan }
+	return h.Input(e,i,po)
+}
+
+/*
+ * Dispatches a frame of any EtherType to the InputFrame method of the VLAN's
+ * Host. Untagged frames are passed to Native, if it implements FrameHandler.
+ */
+func (m *VlanMux) InputFrame(e *eth.EthLayer2, po PacketOutput) error {
+	if e.VLANIdentifier==0 {
+		if fh,ok := m.Native.(FrameHandler); ok { return fh.InputFrame(e,po) }
+		return ENoVlan
+	}
+	h := m.Vlan(e.VLANIdentifier)
+	if h==nil || h.SVlan!=e.SVLANIdentifier { return ENoVlan }
+	return h.InputFrame(e,po)
+}
+
+/*
+ * Runs the timers of all VLAN sub-interfaces. If Native is a *Host or a
+ * *VlanMux, it's timers are run too.
+ */
+func (m *VlanMux) TimerEvent(NOW time.Time) {
+	for _,h := range m.Vlans() { h.TimerEvent(NOW) }
+	switch n := m.Native.(type) {
+	case *Host: n.TimerEvent(NOW)
+	case *VlanMux: n.TimerEvent(NOW)
+	}
+}
+
+/* Returns the neighbor tables of all VLAN sub-interfaces. */
+func (m *VlanMux) Neighbors() (r []NeighborInfo) {
+	for _,h := range m.Vlans() { r = append(r,h.Neighbors()...) }
+	if n,ok := m.Native.(*Host); ok { r = append(r,n.Neighbors()...) }
+	return
+}
@@ -0,0 +1,142 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ip
+
+import "net"
+import "fmt"
+
+var ENoAddr = fmt.Errorf("No such address")
+var EPrefixLen = fmt.Errorf("Invalid prefix length")
+var EAddrExists = fmt.Errorf("Address exists")
+var ENotIPv4 = fmt.Errorf("Not an IPv4 address")
+
+func prefixMask4(plen int) Key4 {
+	if plen==0 { return 0 }
+	return Key4(0xffffffff<<uint(32-plen))
+}
+
+/*
+Adds an IPv4 address with the given prefix length. If brd is nil, the
+broadcast address is derived from the prefix (none for /31, RFC3021, and /32).
+
+The first address of a subnet is it's primary address, further addresses in the
+same subnet are secondary addresses. Primary addresses are preferred as source
+address.
+*/
+func (i *IPHost) AddIPv4Addr(addr net.IP, plen int, brd net.IP) error {
+	a4 := addr.To4()
+	if a4==nil { return ENotIPv4 }
+	if plen<0 || plen>32 { return EPrefixLen }
+	e := &IPv4AddressEntry{PrefixLen:uint8(plen)}
+	e.Addr.Decode(a4)
+	e.Subnetmask = prefixMask4(plen)
+	if b4 := brd.To4(); b4!=nil {
+		e.Broadcast.Decode(b4)
+	} else if plen<31 {
+		e.Broadcast = e.Addr|^e.Subnetmask
+	}
+	i.Lock(); defer i.Unlock()
+	if _,ok := i.V4[e.Addr]; ok { return EAddrExists }
+	for _,o := range i.V4 {
+		if !o.Secondary && o.Subnetmask==e.Subnetmask && o.Network()==e.Network() { e.Secondary = true }
+	}
+	i.V4[e.Addr] = e
+	i.addBroadcast4(e,1)
+	return nil
+}
+
+/*
+Registers (n=1) or unregisters (n=-1) the broadcast addresses of an entry.
+
+RFC1122 3.3.6:
+  There is a class of hosts (4.2BSD Unix and its derivatives, but not 4.3BSD)
+  that use non-standard broadcast address forms, substituting 0 for -1. All
+  hosts SHOULD recognize and accept any of these non-standard broadcast
+  addresses as the destination address of an incoming datagram.
+*/
+func (i *IPHost) addBroadcast4(e *IPv4AddressEntry, n int) {
+	bs := make([]Key4,0,2)
+	if e.Broadcast!=0 { bs = append(bs,e.Broadcast) }
+	if e.PrefixLen<31 { bs = append(bs,e.Network()) }
+	for _,b := range bs {
+		i.B4[b] += n
+		if i.B4[b]<=0 { delete(i.B4,b) }
+	}
+}
+
+func (i *IPHost) removeIP4Addr(addr net.IP) error {
+	var k Key4
+	k.Decode(addr)
+	i.Lock(); defer i.Unlock()
+	e,ok := i.V4[k]
+	if !ok { return ENoAddr }
+	delete(i.V4,k)
+	i.addBroadcast4(e,-1)
+	if e.Secondary { return nil }
+	
+	/* Promote a secondary address of the subnet. */
+	var p *IPv4AddressEntry
+	for _,o := range i.V4 {
+		if o.Subnetmask!=e.Subnetmask || o.Network()!=e.Network() { continue }
+		if p==nil || o.Addr<p.Addr { p = o }
+	}
+	if p!=nil { p.Secondary = false }
+	return nil
+}
+
+// Returns the network address (the address with all host bits 0).
+func (e *IPv4AddressEntry) Network() Key4 { return e.Addr&e.Subnetmask }
+
+// Returns the address as net.IPNet.
+func (e *IPv4AddressEntry) IPNet() *net.IPNet {
+	return &net.IPNet{IP:e.Addr.IP(),Mask:net.CIDRMask(int(e.PrefixLen),32)}
+}
+
+/*
+Reports, whether targ is the limited broadcast address (255.255.255.255) or a
+directed broadcast address of one of our subnets.
+*/
+func (i *IPHost) IsBroadcast(targ net.IP) bool {
+	t4 := targ.To4()
+	if t4==nil { return false }
+	var k Key4
+	k.Decode(t4)
+	if k==0xffffffff { return true }
+	i.RLock(); defer i.RUnlock()
+	return i.B4[k]>0
+}
+
+// Returns the IPv4 addresses, primary addresses first.
+func (i *IPHost) Addrs4() []IPv4AddressEntry {
+	i.RLock(); defer i.RUnlock()
+	es := make([]IPv4AddressEntry,0,len(i.V4))
+	for _,e := range i.V4 {
+		if !e.Secondary { es = append(es,*e) }
+	}
+	for _,e := range i.V4 {
+		if e.Secondary { es = append(es,*e) }
+	}
+	return es
+}
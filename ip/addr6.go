@@ -0,0 +1,354 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ip
+
+import "net"
+import "sync"
+import "time"
+
+/*
+States of an IPv6 address (RFC4862 2, 5.5.4).
+*/
+type ADDR_STATE uint8
+const (
+	/* Duplicate Address Detection is running, the address is not assigned. */
+	ADDR_TENTATIVE = ADDR_STATE(iota)
+	
+	/* The address may be used without restriction. */
+	ADDR_PREFERRED
+	
+	/*
+	 * The preferred lifetime expired. The address remains valid for existing
+	 * communication, but is avoided for new communication.
+	 */
+	ADDR_DEPRECATED
+	
+	/* The valid lifetime expired, DAD failed or the address was removed. */
+	ADDR_INVALID
+)
+
+func (s ADDR_STATE) String() string {
+	switch s {
+	case ADDR_TENTATIVE: return "tentative"
+	case ADDR_PREFERRED: return "preferred"
+	case ADDR_DEPRECATED: return "deprecated"
+	case ADDR_INVALID: return "invalid"
+	}
+	return "?"
+}
+
+/* An infinite lifetime (RFC4861 4.6.2). */
+const LIFETIME_INFINITE = 0xffffffff
+
+/*
+An address state change.
+
+Subscribers of an IPHost receive *AddrEvent values through their Notify
+method. Notify is called after the IPHost is unlocked.
+*/
+type AddrEvent struct{
+	Addr net.IP
+	State, OldState ADDR_STATE
+	
+	/* Set, if the address became invalid, because DAD detected a duplicate. */
+	Duplicate bool
+}
+
+type Notifyable interface{
+	Notify(i interface{})
+}
+
+type addrSubscribers struct{
+	mutex sync.RWMutex
+	list []Notifyable
+}
+
+func (i *IPHost) Subscribe(n Notifyable) {
+	s := &i.events
+	s.mutex.Lock(); defer s.mutex.Unlock()
+	s.list = append(s.list,n)
+}
+func (i *IPHost) Unsubscribe(n Notifyable) {
+	s := &i.events
+	s.mutex.Lock(); defer s.mutex.Unlock()
+	for j,o := range s.list {
+		if o!=n { continue }
+		s.list = append(s.list[:j:j],s.list[j+1:]...)
+		return
+	}
+}
+/*
+Returns a copy of the subscriber list, so that subscribers may call Subscribe
+or Unsubscribe from their Notify method.
+*/
+func (s *addrSubscribers) get() []Notifyable {
+	s.mutex.RLock(); defer s.mutex.RUnlock()
+	return append([]Notifyable(nil),s.list...)
+}
+func (i *IPHost) notify(evs []*AddrEvent) {
+	if len(evs)==0 { return }
+	subs := i.events.get()
+	for _,ev := range evs {
+		for _,n := range subs { n.Notify(ev) }
+	}
+}
+
+// Changes the state of a (locked) entry, and records the event.
+func (a *IPv6AddressEntry) setState(s ADDR_STATE, evs []*AddrEvent) []*AddrEvent {
+	if a.State==s { return evs }
+	evs = append(evs,&AddrEvent{Addr:a.Unicast.IP(),State:s,OldState:a.State})
+	a.State = s
+	return evs
+}
+
+// Reports, whether the address is assigned (not tentative or invalid).
+func (a *IPv6AddressEntry) Assigned() bool {
+	return a.State==ADDR_PREFERRED || a.State==ADDR_DEPRECATED
+}
+
+func lifetimeExpired(lt uint32, since time.Duration) bool {
+	if lt==LIFETIME_INFINITE { return false }
+	return since >= time.Duration(lt)*time.Second
+}
+
+/*
+Returns the remaining valid and preferred lifetimes (in seconds), as of NOW.
+*/
+func (a *IPv6AddressEntry) Remaining(NOW time.Time) (valid, preferred uint32) {
+	since := uint32(NOW.Sub(a.Tstamp)/time.Second)
+	rem := func(lt uint32) uint32 {
+		if lt==LIFETIME_INFINITE { return lt }
+		if since>=lt { return 0 }
+		return lt-since
+	}
+	return rem(a.ValidLifetime),rem(a.PreferredLifetime)
+}
+
+/*
+Adds an IPv6 address with the given lifetimes (in seconds). A tentative address
+is not assigned, until Duplicate Address Detection completes (see DADProbe).
+*/
+func (i *IPHost) AddIPv6Addr(addr net.IP, tentative bool, valid, preferred uint32) (*IPv6AddressEntry, error) {
+	return i.addIP6(addr,tentative,false,valid,preferred)
+}
+
+/*
+Adds an anycast address, like the Subnet-Router anycast address (the prefix
+with an all-zero interface identifier, RFC4291 2.6.1).
+
+Anycast addresses are accepted as destination, but never used as source
+address. RFC4862 5.4: Duplicate Address Detection MUST NOT be performed on
+anycast addresses.
+*/
+func (i *IPHost) AddAnycast6(addr net.IP) (*IPv6AddressEntry, error) {
+	return i.addIP6(addr,false,true,LIFETIME_INFINITE,LIFETIME_INFINITE)
+}
+
+func (i *IPHost) addIP6(addr net.IP, tentative, anycast bool, valid, preferred uint32) (*IPv6AddressEntry, error) {
+	if len(addr)!=16 || addr.To4()!=nil || addr[0]==0xff { return nil,ENoAddr }
+	if valid==0 { return nil,ENoAddr }
+	var i6 Key6
+	i6.Decode(addr)
+	i.Lock()
+	if _,ok := i.V6[i6]; ok { i.Unlock(); return nil,EAddrExists }
+	a,evs := i.insertIP6(i6,tentative,anycast,valid,preferred,time.Now(),nil)
+	i.Unlock()
+	i.notify(evs)
+	return a,nil
+}
+
+// Inserts a new address into the (locked) IPHost, and records the event.
+func (i *IPHost) insertIP6(i6 Key6, tentative, anycast bool, valid, preferred uint32, NOW time.Time, evs []*AddrEvent) (*IPv6AddressEntry, []*AddrEvent) {
+	var m6 Key6
+	/* Solicited Multicast address */
+	m6.Hi = 0xff02000000000000
+	m6.Lo = 0x00000001ff000000|(i6.Lo&0xffffff)
+	a := new(IPv6AddressEntry)
+	a.Unicast = i6
+	a.SolicitedMulticast = m6
+	a.Anycast = anycast
+	a.ValidLifetime = valid
+	a.PreferredLifetime = preferred
+	a.Tstamp = NOW
+	a.State = ADDR_TENTATIVE
+	if !tentative { a.State = assignedState(a,NOW) }
+	i.V6[i6] = a
+	i.S6[m6] = a
+	i.join6(m6)
+	return a,append(evs,&AddrEvent{Addr:i6.IP(),State:a.State,OldState:ADDR_INVALID})
+}
+
+/*
+The state of an address, leaving the tentative state. The preferred lifetime may
+have expired during Duplicate Address Detection.
+*/
+func assignedState(a *IPv6AddressEntry, NOW time.Time) ADDR_STATE {
+	if lifetimeExpired(a.PreferredLifetime,NOW.Sub(a.Tstamp)) { return ADDR_DEPRECATED }
+	return ADDR_PREFERRED
+}
+
+/*
+Sets the lifetimes of an address (in seconds), counted from NOW. A preferred
+lifetime of 0 deprecates the address, a valid lifetime of 0 removes it.
+*/
+func (i *IPHost) SetLifetimes(a *IPv6AddressEntry, valid, preferred uint32, NOW time.Time) {
+	var evs []*AddrEvent
+	i.Lock()
+	if i.V6[a.Unicast]==a {
+		a.ValidLifetime = valid
+		a.PreferredLifetime = preferred
+		a.Tstamp = NOW
+		switch {
+		case valid==0:
+			evs = i.invalidate(a,false,evs)
+		case a.State==ADDR_TENTATIVE:
+		case preferred==0:
+			evs = a.setState(ADDR_DEPRECATED,evs)
+		default:
+			evs = a.setState(ADDR_PREFERRED,evs)
+		}
+	}
+	i.Unlock()
+	i.notify(evs)
+}
+
+// Removes a (locked) address, and records the event.
+func (i *IPHost) invalidate(a *IPv6AddressEntry, dup bool, evs []*AddrEvent) []*AddrEvent {
+	if !i.removeIP6(a) { return evs }
+	evs = a.setState(ADDR_INVALID,evs)
+	evs[len(evs)-1].Duplicate = dup
+	return evs
+}
+
+/*
+Runs the lifetime timers of the IPv6 prefixes and addresses: Expired prefixes
+are removed. Addresses, whose preferred lifetime expired, become deprecated.
+Addresses, whose valid lifetime expired, become invalid and are removed.
+*/
+func (i *IPHost) TimerEvent(NOW time.Time) {
+	i.prefixTimer(NOW)
+	var evs []*AddrEvent
+	i.Lock()
+	for _,a := range i.V6 {
+		since := NOW.Sub(a.Tstamp)
+		switch {
+		case lifetimeExpired(a.ValidLifetime,since):
+			evs = i.invalidate(a,false,evs)
+		case a.State==ADDR_PREFERRED && lifetimeExpired(a.PreferredLifetime,since):
+			evs = a.setState(ADDR_DEPRECATED,evs)
+		}
+	}
+	i.Unlock()
+	i.notify(evs)
+}
+
+/*
+Advances Duplicate Address Detection (RFC4862 5.4) of the tentative addresses.
+Returns the addresses, a Neighbor Solicitation must be sent for. A tentative
+address becomes assigned, after 'transmits' solicitations were sent and
+'retrans' has passed since the last one, without a duplicate being detected.
+*/
+func (i *IPHost) DADProbe(NOW time.Time, retrans time.Duration, transmits int) (probe []net.IP) {
+	var evs []*AddrEvent
+	i.Lock()
+	for k,a := range i.V6 {
+		if a.State!=ADDR_TENTATIVE { continue }
+		if a.DADProbes>0 && NOW.Sub(a.DADTstamp)<retrans { continue }
+		if a.DADProbes>=transmits {
+			evs = a.setState(assignedState(a,NOW),evs)
+			continue
+		}
+		a.DADProbes++
+		a.DADTstamp = NOW
+		probe = append(probe,k.IP())
+	}
+	i.Unlock()
+	i.notify(evs)
+	return
+}
+
+/*
+Reports a duplicate of a tentative address (RFC4862 5.4.3, 5.4.4). The address
+is removed.
+*/
+func (i *IPHost) DADFailed(a *IPv6AddressEntry) {
+	i.Lock()
+	evs := i.invalidate(a,true,nil)
+	i.Unlock()
+	i.notify(evs)
+}
+
+/*
+Applies the lifetimes of a received Prefix Information option to the addresses
+of the prefix (in it's List).
+
+RFC4862 5.5.3 (e):
+  1.  If the received Valid Lifetime is greater than 2 hours or greater
+      than RemainingLifetime, set the valid lifetime of the
+      corresponding address to the advertised Valid Lifetime.
+  2.  If RemainingLifetime is less than or equal to 2 hours, ignore the
+      Prefix Information option with regard to the valid lifetime.
+  3.  Otherwise, reset the valid lifetime of the corresponding address
+      to 2 hours.
+  The preferred lifetime of the address is reset to the Preferred Lifetime
+  in the received advertisement.
+*/
+func (i *IPHost) UpdatePrefixLifetimes(pe *IPv6PrefixEntry, valid, preferred uint32, NOW time.Time) {
+	i.Lock()
+	evs := i.updateLifetimes(pe,valid,preferred,NOW,nil)
+	i.Unlock()
+	i.notify(evs)
+}
+
+// Applies the new lifetimes to the addresses of a prefix. The IPHost must be locked.
+func (i *IPHost) updateLifetimes(pe *IPv6PrefixEntry, valid, preferred uint32, NOW time.Time, evs []*AddrEvent) []*AddrEvent {
+	for _,a := range i.prefixAddrs(pe) {
+		evs = a.updateLifetimes(valid,preferred,NOW,evs)
+	}
+	return evs
+}
+
+// Applies the new lifetimes to an address (RFC4862 5.5.3 (e)).
+func (a *IPv6AddressEntry) updateLifetimes(valid, preferred uint32, NOW time.Time, evs []*AddrEvent) []*AddrEvent {
+	const twoHours = 2*60*60
+	lt := valid
+	remaining,_ := a.Remaining(NOW)
+	switch {
+	case lt>twoHours || lt>remaining:
+	case remaining<=twoHours: lt = remaining
+	default: lt = twoHours
+	}
+	a.ValidLifetime = lt
+	a.PreferredLifetime = preferred
+	a.Tstamp = NOW
+	switch {
+	case a.State==ADDR_TENTATIVE:
+	case preferred==0:
+		evs = a.setState(ADDR_DEPRECATED,evs)
+	default:
+		evs = a.setState(ADDR_PREFERRED,evs)
+	}
+	return evs
+}
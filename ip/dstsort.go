@@ -0,0 +1,170 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ip
+
+import "net"
+import "sort"
+
+/*
+A candidate destination address and the state of the stack, it is sorted
+by (RFC 6724 6).
+*/
+type Destination struct{
+	IP net.IP
+	
+	/* Whether the destination is reachable and has a source address. */
+	Usable bool
+	
+	/* Source(D) and it's state. */
+	Src net.IP
+	Deprecated bool
+	Home bool
+	
+	/*
+	 * The prefix length of Source(D), CommonPrefixLen is limited to (Rule 9).
+	 * For IPv4, it counts from the IPv4-mapped address (96 + the prefix
+	 * length). 0 means no limit.
+	 */
+	SrcPrefixLen uint8
+	
+	/* Label(D), Label(Source(D)) and Precedence(D). */
+	Label, SrcLabel uint8
+	Precedence uint8
+}
+
+/*
+Returns the Destination for dst, with Source(D) selected by SelectSource.
+Usable is set, if there is a source address. Reachability (routes, neighbors)
+is not known to the IPHost, it is to be checked by the caller.
+*/
+func (i *IPHost) Destination(dst net.IP) (d Destination) {
+	pt := i.PolicyTable()
+	d.IP = dst
+	d.Label = pt.Label(dst)
+	d.Precedence = pt.Precedence(dst)
+	d.Src = i.SelectSource(dst)
+	if d.Src==nil { return }
+	d.Usable = true
+	d.SrcLabel = pt.Label(d.Src)
+	if s4 := d.Src.To4(); s4!=nil {
+		var k Key4
+		k.Decode(s4)
+		i.RLock()
+		if e,ok := i.V4[k]; ok { d.SrcPrefixLen = 96+e.PrefixLen }
+		i.RUnlock()
+	} else {
+		/* Without a prefix, the interface identifier is 64 bits (RFC4291 2.5.1). */
+		d.SrcPrefixLen = 64
+		if a,ok := i.GetTarget6(d.Src); ok {
+			d.Deprecated = a.State==ADDR_DEPRECATED
+			d.Home = a.Home
+			if a.Prefix!=nil { d.SrcPrefixLen = a.Prefix.Prefix.Len }
+		}
+	}
+	return
+}
+
+// CommonPrefixLen(Source(D), D), with IPv4 addresses IPv4-mapped.
+func (d *Destination) commonPrefixLen() int {
+	plen := int(d.SrcPrefixLen)
+	if plen==0 { plen = 128 }
+	return commonPrefixLen(d.Src.To16(),d.IP.To16(),plen)
+}
+
+/*
+Reports, whether D uses an encapsulating transition mechanism (6to4 or
+Teredo), rather than native transport.
+*/
+func isTunneled(d net.IP) bool {
+	if d.To4()!=nil || len(d)!=16 { return false }
+	if d[0]==0x20 && d[1]==0x02 { return true } /* 2002::/16 */
+	return d[0]==0x20 && d[1]==0x01 && d[2]==0 && d[3]==0 /* 2001::/32 */
+}
+
+/*
+RFC 6724 6. Destination Address Selection
+
+Reports, whether DA is preferred over DB.
+*/
+func preferDestination(a, b *Destination) bool {
+	/* Rule 1: Avoid unusable destinations. */
+	if a.Usable!=b.Usable { return a.Usable }
+	if !a.Usable { return false }
+	
+	/* Rule 2: Prefer matching scope. */
+	ma := Scope(a.IP)==Scope(a.Src)
+	mb := Scope(b.IP)==Scope(b.Src)
+	if ma!=mb { return ma }
+	
+	/* Rule 3: Avoid deprecated addresses. */
+	if a.Deprecated!=b.Deprecated { return b.Deprecated }
+	
+	/* Rule 4: Prefer home addresses. */
+	if a.Home!=b.Home { return a.Home }
+	
+	/* Rule 5: Prefer matching label. */
+	ma = a.SrcLabel==a.Label
+	mb = b.SrcLabel==b.Label
+	if ma!=mb { return ma }
+	
+	/* Rule 6: Prefer higher precedence. */
+	if a.Precedence!=b.Precedence { return a.Precedence>b.Precedence }
+	
+	/* Rule 7: Prefer native transport. */
+	ta,tb := isTunneled(a.IP),isTunneled(b.IP)
+	if ta!=tb { return tb }
+	
+	/* Rule 8: Prefer smaller scope. */
+	sa,sb := Scope(a.IP),Scope(b.IP)
+	if sa!=sb { return sa<sb }
+	
+	/*
+	 * Rule 9: Use longest matching prefix.
+	 *   When DA and DB belong to the same address family (both are IPv6 or
+	 *   both are IPv4 [but see below]): If CommonPrefixLen(Source(DA), DA) >
+	 *   CommonPrefixLen(Source(DB), DB), then prefer DA.
+	 */
+	if a.Src!=nil && b.Src!=nil && (a.IP.To4()==nil)==(b.IP.To4()==nil) {
+		/* IPv4 addresses are compared IPv4-mapped, sharing their first 96 bits. */
+		if ca,cb := a.commonPrefixLen(),b.commonPrefixLen(); ca!=cb { return ca>cb }
+	}
+	
+	/* Rule 10: Otherwise, leave the order unchanged. */
+	return false
+}
+
+/*
+Sorts the destinations by RFC 6724 6, most preferred first. The sort is stable,
+destinations, that are equal by all rules, keep their order.
+
+Usable should reflect the reachability of the destinations. The
+reachability-aware variants are icmp.Host.SortDestinations and
+icmp.Node.SortDestinations.
+*/
+func SortDestinations(ds []Destination) {
+	sort.SliceStable(ds,func(i,j int) bool {
+		return preferDestination(&ds[i],&ds[j])
+	})
+}
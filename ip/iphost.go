@@ -43,7 +43,8 @@ type IPv6Prefix struct{
 
 type IPv6PrefixEntry struct{
 	Prefix IPv6Prefix
-	Lifetime uint32
+	Lifetime uint32 /* The valid lifetime. */
+	PreferredLifetime uint32
 	Tstamp time.Time
 	Onlink bool
 	Slaac bool
@@ -55,7 +56,31 @@ type IPv6PrefixEntry struct{
 type IPv6AddressEntry struct{
 	Unicast, SolicitedMulticast Key6
 	
-	Tentative bool
+	State ADDR_STATE
+	
+	/*
+	 * RFC4862 5.5.3: Valid and preferred lifetime in seconds, counted from
+	 * Tstamp (LIFETIME_INFINITE for infinity).
+	 */
+	ValidLifetime, PreferredLifetime uint32
+	Tstamp time.Time
+	
+	/* Duplicate Address Detection: solicitations sent, time of the last one. */
+	DADProbes int
+	DADTstamp time.Time
+	
+	/* An anycast address (see AddAnycast6). */
+	Anycast bool
+	
+	/*
+	 * RFC 6724 5: Home addresses (RFC 6275) and temporary addresses
+	 * (RFC 4941) are preferred (Rules 4 and 7).
+	 */
+	Home bool
+	Temporary bool
+	
+	/* Formed by stateless address autoconfiguration (RFC4862 5.5.3). */
+	Autoconf bool
 	
 	/*
 	 * The prefix this IPv6 Address was derived from, if any.
@@ -65,21 +90,60 @@ type IPv6AddressEntry struct{
 	Prefix *IPv6PrefixEntry
 }
 type IPv4AddressEntry struct{
-	Addr, Subnetmask, Gateway Key4
+	Addr, Subnetmask Key4
+	
+	/*
+	 * Deprecated: The gateway, guessed by AddIPAddr as the first address of
+	 * the /24 subnet. It is not used by this package.
+	 */
+	Gateway Key4
+	
+	/* The prefix length and the broadcast address (0, if none). */
+	PrefixLen uint8
+	Broadcast Key4
+	
+	/* Set for all, but the first address of a subnet. */
+	Secondary bool
 }
 
 type IPHost struct {
 	sync.RWMutex
+	Zone string /* The zone (interface name) of link-local addresses. */
 	V4 map[Key4]*IPv4AddressEntry
 	V6 map[Key6]*IPv6AddressEntry
 	S6 map[Key6]*IPv6AddressEntry
 	Prefix6 map[IPv6Prefix]*IPv6PrefixEntry
+	
+	/* Directed broadcast addresses, with their reference counts. */
+	B4 map[Key4]int
+	
+	/* Joined multicast groups, with their reference counts. */
+	M4 map[Key4]int
+	M6 map[Key6]int
+	
+	/* The share of the reference counts, joined with JoinGroup. */
+	j4 map[Key4]int
+	j6 map[Key6]int
+	
+	events addrSubscribers
+	
+	/*
+	 * The RFC 6724 policy table (DefaultPolicyTable, if nil). If PreferPublic
+	 * is set, public addresses are preferred over temporary ones.
+	 */
+	Policy PolicyTable
+	PreferPublic bool
 }
 func (i *IPHost) Init() *IPHost{
 	i.V4 = make(map[Key4]*IPv4AddressEntry)
 	i.V6 = make(map[Key6]*IPv6AddressEntry)
 	i.S6 = make(map[Key6]*IPv6AddressEntry)
 	i.Prefix6 = make(map[IPv6Prefix]*IPv6PrefixEntry)
+	i.B4 = make(map[Key4]int)
+	i.M4 = map[Key4]int{allHosts4:1}
+	i.M6 = map[Key6]int{allNodes6:1}
+	i.j4 = make(map[Key4]int)
+	i.j6 = make(map[Key6]int)
 	return i
 }
 func (i *IPHost) input4(targ net.IP) (my bool) {
@@ -87,6 +151,8 @@ func (i *IPHost) input4(targ net.IP) (my bool) {
 	i4.Decode(targ)
 	if i4==0xFFFFFFFF { return true }
 	i.RLock(); defer i.RUnlock()
+	if isMulticast4(i4) { return i.M4[i4]>0 }
+	if i.B4[i4]>0 { return true }
 	_,my = i.V4[i4]
 	return
 }
@@ -123,14 +189,36 @@ func (i *IPHost) input6(targ net.IP) (my bool) {
 		 */
 		// (Case 1)
 		case  0,1: return false
-		default: return true
 		}
+		
+		/* Only joined groups are accepted. */
+		i.RLock(); defer i.RUnlock()
+		i6.Decode(targ)
+		return i.M6[i6]>0
 	}
 	
 	i.RLock(); defer i.RUnlock()
 	i6.Decode(targ)
-	_,my = i.V6[i6]
-	return
+	/* RFC4862 5.4: Packets to tentative addresses are silently discarded. */
+	a,ok := i.V6[i6]
+	return ok && a.Assigned()
+}
+/*
+Reports, whether targ is one of our unicast addresses (and not a broadcast,
+multicast or anycast address).
+*/
+func (i *IPHost) IsUnicast(targ net.IP) bool {
+	i.RLock(); defer i.RUnlock()
+	if t4 := targ.To4(); t4!=nil {
+		var i4 Key4
+		i4.Decode(t4)
+		_,ok := i.V4[i4]
+		return ok
+	}
+	var i6 Key6
+	i6.Decode(targ)
+	a,ok := i.V6[i6]
+	return ok && a.Assigned() && !a.Anycast
 }
 func (i *IPHost) GetTarget6(targ net.IP) (obj *IPv6AddressEntry, my bool) {
 	var i6 Key6
@@ -139,6 +227,24 @@ func (i *IPHost) GetTarget6(targ net.IP) (obj *IPv6AddressEntry, my bool) {
 	obj,my = i.V6[i6]
 	return
 }
+// Returns a usable (non-tentative) link-local address, or nil if none exists.
+func (i *IPHost) LinkLocal6() net.IP {
+	i.RLock(); defer i.RUnlock()
+	for k,addr := range i.V6 {
+		if !addr.Assigned() || addr.Anycast { continue }
+		if (k.Hi>>48)==0xfe80 { return k.IP() }
+	}
+	return nil
+}
+/*
+ * Selects a source address for packets sent to 'dst', following the RFC 6724
+ * rules. Returns nil, if no usable address exists.
+ */
+func (i *IPHost) SelectSource(dst net.IP) net.IP {
+	if d4 := dst.To4(); d4!=nil { return i.selectSource4(d4) }
+	if len(dst)!=16 { return nil }
+	return i.selectSource6(dst)
+}
 func (i *IPHost) Input(targ net.IP) (my bool) {
 	switch len(targ) {
 	case 4:
@@ -148,54 +254,60 @@ func (i *IPHost) Input(targ net.IP) (my bool) {
 	}
 	return
 }
-func (i *IPHost) SlaacFailedV6(addr *IPv6AddressEntry) {
-	i.Lock(); defer i.Unlock()
+// Deprecated: use DADFailed.
+func (i *IPHost) SlaacFailedV6(addr *IPv6AddressEntry) { i.DADFailed(addr) }
+func (i *IPHost) removeIP6(addr *IPv6AddressEntry) bool {
+	if i.V6[addr.Unicast]!=addr { return false }
 	delete(i.V6,addr.Unicast)
-	delete(i.S6,addr.SolicitedMulticast)
-}
-func (i *IPHost) addIP4Addr(ip, sn, gw net.IP) {
-	var i4,s4,g4 Key4
-	i4.Decode(ip)
-	i.Lock(); defer i.Unlock()
-	addr,_ := i.V4[i4]
-	if addr!=nil { return }
-	if len(sn)==4 {
-		s4.Decode(sn)
-	}else{
-		s4 = 0xFFFFFF00
-	}
-	if len(gw)==4 {
-		g4.Decode(gw)
-	}else{
-		g4 = i4&0xFFFFFF00
-	}
-	
-	addr = &IPv4AddressEntry{i4,s4,g4}
-	i.V4[i4] = addr
-	i.V4[i4|^s4] = addr
-}
-func (i *IPHost) addIP6Addr(ip net.IP) {
-	var i6,m6 Key6
-	i6.Decode(ip)
-	i.Lock(); defer i.Unlock()
-	addr,_ := i.V6[i6]
-	if addr!=nil { return }
-	/* Solicited Multicast address */
-	m6.Hi = 0xff02000000000000
-	m6.Lo = 0x00000001ff000000|(i6.Lo&0xffffff)
-	addr = new(IPv6AddressEntry)
-	addr.Unicast = i6
-	addr.SolicitedMulticast = m6
-	i.V6[i6] = addr
-	i.S6[m6] = addr
+	if addr.Prefix!=nil { addr.Prefix.disown(addr) }
+	if i.S6[addr.SolicitedMulticast]==addr { delete(i.S6,addr.SolicitedMulticast) }
+	i.leave6(addr.SolicitedMulticast)
+	return true
 }
+/*
+Adds an address. IPv4 addresses are added as /24 (see AddIPv4Addr), IPv6
+addresses as preferred addresses with infinite lifetimes (see AddIPv6Addr).
+
+Deprecated for IPv4 addresses: The /24 subnet and the Gateway are guessed. Use
+AddIPv4Addr with the prefix length of the subnet instead.
+*/
 func (i *IPHost) AddIPAddr(ip net.IP) {
+	if ip4 := ip.To4(); ip4!=nil { ip = ip4 }
+	switch len(ip) {
+	case 4:
+		if i.AddIPv4Addr(ip,24,nil)!=nil { return }
+		var k Key4
+		k.Decode(ip)
+		i.Lock()
+		if e,ok := i.V4[k]; ok { e.Gateway = k&0xffffff00 }
+		i.Unlock()
+	case 16:
+		i.AddIPv6Addr(ip,false,LIFETIME_INFINITE,LIFETIME_INFINITE)
+	}
+}
+
+/*
+Removes an address, together with it's broadcast addresses (IPv4) or it's
+solicited-node group membership (IPv6). Subnets of IPv4 addresses are no
+longer on-link, after their last address is removed.
+*/
+func (i *IPHost) RemoveIPAddr(ip net.IP) error {
+	if ip4 := ip.To4(); ip4!=nil { ip = ip4 }
 	switch len(ip) {
 	case 4:
-		i.addIP4Addr(ip,nil,nil)
+		return i.removeIP4Addr(ip)
 	case 16:
-		i.addIP6Addr(ip)
+		var k Key6
+		k.Decode(ip)
+		i.Lock()
+		addr,ok := i.V6[k]
+		if !ok { i.Unlock(); return ENoAddr }
+		evs := i.invalidate(addr,false,nil)
+		i.Unlock()
+		i.notify(evs)
+		return nil
 	}
+	return ENoAddr
 }
 
 func (i *IPHost) extractPrefixes() []IPv6Prefix {	
@@ -205,7 +317,31 @@ func (i *IPHost) extractPrefixes() []IPv6Prefix {
 	return px
 }
 
+// Returns all addresses, link-local addresses carry the Zone.
+func (i *IPHost) Addrs() []net.IPAddr {
+	i.RLock(); defer i.RUnlock()
+	addrs := make([]net.IPAddr,0,len(i.V4)+len(i.V6))
+	for k := range i.V4 {
+		addrs = append(addrs,net.IPAddr{IP:k.IP()})
+	}
+	for k := range i.V6 {
+		a := net.IPAddr{IP:k.IP()}
+		if IsLinkLocal(a.IP) { a.Zone = i.Zone }
+		addrs = append(addrs,a)
+	}
+	return addrs
+}
+
 func (i *IPHost) IsOnLink(ip net.IP) bool {
+	if i4 := ip.To4(); i4!=nil {
+		var k4 Key4
+		k4.Decode(i4)
+		i.RLock(); defer i.RUnlock()
+		for _,addr := range i.V4 {
+			if (k4&addr.Subnetmask)==(addr.Addr&addr.Subnetmask) { return true }
+		}
+		return false
+	}
 	if	ip[0]==0xfe &&
 		ip[1]==0x80 &&
 		ip[2]==0 &&
@@ -216,13 +352,7 @@ func (i *IPHost) IsOnLink(ip net.IP) bool {
 		ip[7]==0 { return true }
 	i.RLock(); defer i.RUnlock()
 	for px,pobj := range i.Prefix6 {
-		eq := byte(0)
-		lng := int(px.Len)
-		hi := lng>>3
-		lo := lng&7
-		for i:=0 ; i<hi; i++ { eq |= px.IP[i]^ip[i] }
-		if lo!=0 {   eq |= (px.IP[hi]^ip[hi]) & bitbytes[lo]   }
-		if eq==0 && pobj.Onlink { return true }
+		if pobj.Onlink && px.Match(ip) { return true }
 	}
 	return false
 }
@@ -29,9 +29,30 @@ package ip
 
 import "github.com/google/gopacket"
 import "github.com/google/gopacket/layers"
+import "encoding/binary"
 import "net"
 import "fmt"
 
+var ENotNetwork = fmt.Errorf("Neither IPv4, IPv6 nor ARP")
+
+/*
+The LayerType of IPLayerPart. It decodes IPv4, IPv6 (with extension headers)
+and ARP, and is registered with a decoder, so that it can be used with
+gopacket.NewPacket.
+*/
+var LayerTypeIPLayerPart = gopacket.RegisterLayerType(1201,gopacket.LayerTypeMetadata{Name:"IPLayerPart",Decoder:gopacket.DecodeFunc(decodeIPLayerPart)})
+
+var ipLayerClass = gopacket.NewLayerClass([]gopacket.LayerType{LayerTypeIPLayerPart,layers.LayerTypeIPv4,layers.LayerTypeIPv6,layers.LayerTypeARP})
+
+/*
+Decodes the network layer. NextType is the LayerType of the payload (after
+IPv6 extension headers). In earlier versions, this field was named
+NextLayerType, which is now the method required by gopacket.DecodingLayer
+(returning NextType).
+
+IPLayerPart implements gopacket.DecodingLayer. In a gopacket.DecodingLayerParser,
+it decodes IPv4, IPv6 and ARP.
+*/
 type IPLayerPart struct {
 	layers.BaseLayer
 	V4 layers.IPv4
@@ -39,7 +60,15 @@ type IPLayerPart struct {
 	AR4 layers.ARP
 	ES6 layers.IPv6ExtensionSkipper
 	NetworkFlow   gopacket.Flow
-	NextLayerType gopacket.LayerType
+	NextType gopacket.LayerType
+	
+	/*
+	 * The upper layer protocol (after IPv6 extension headers) and the offset
+	 * of the field holding it, from the start of the IP header.
+	 */
+	Protocol layers.IPProtocol
+	NextHeaderOffset int
+	
 	SrcIP net.IP
 	DstIP net.IP
 	SrcMac net.HardwareAddr
@@ -54,7 +83,9 @@ func (ip *IPLayerPart) DecodeType(t gopacket.LayerType,data []byte, df gopacket.
 		err = ip.V4.DecodeFromBytes(data,df)
 		ip.BaseLayer = ip.V4.BaseLayer
 		ip.NetworkFlow = ip.V4.NetworkFlow()
-		ip.NextLayerType = ip.V4.NextLayerType()
+		ip.NextType = ip.V4.NextLayerType()
+		ip.Protocol = ip.V4.Protocol
+		ip.NextHeaderOffset = 9
 		ip.SrcIP = ip.V4.SrcIP
 		ip.DstIP = ip.V4.DstIP
 		ip.IsAR = false
@@ -63,7 +94,9 @@ func (ip *IPLayerPart) DecodeType(t gopacket.LayerType,data []byte, df gopacket.
 		err = ip.V6.DecodeFromBytes(data,df)
 		ip.BaseLayer = ip.V6.BaseLayer
 		ip.NetworkFlow = ip.V6.NetworkFlow()
-		ip.NextLayerType = ip.V6.NextLayerType()
+		ip.NextType = ip.V6.NextLayerType()
+		ip.Protocol = ip.V6.NextHeader
+		ip.NextHeaderOffset = 6
 		ip.SrcIP = ip.V6.SrcIP
 		ip.DstIP = ip.V6.DstIP
 		ip.IsAR = false
@@ -77,6 +110,11 @@ func (ip *IPLayerPart) DecodeType(t gopacket.LayerType,data []byte, df gopacket.
 		ip.DstIP = net.IP(ip.AR4.DstProtAddress)
 		ip.SrcMac = net.HardwareAddr(ip.AR4.SourceHwAddress)
 		ip.DstMac = net.HardwareAddr(ip.AR4.DstHwAddress)
+		ip.BaseLayer = ip.AR4.BaseLayer
+		ip.NetworkFlow = gopacket.Flow{}
+		ip.NextType = gopacket.LayerTypeZero
+		ip.Protocol = 0
+		ip.NextHeaderOffset = 0
 		ip.IsAR = true
 		ip.IsV6 = false
 	default:
@@ -84,26 +122,68 @@ func (ip *IPLayerPart) DecodeType(t gopacket.LayerType,data []byte, df gopacket.
 	}
 	return
 }
+/*
+Decodes IPv4, IPv6 or ARP, telling them apart by the version nibble. ARP is
+accepted for IPv4 over Ethernet (or IEEE 802) only: hardware type 1 (or 6),
+protocol type 0x0800 and address lengths 6 and 4 (RFC826).
+*/
+func (ip *IPLayerPart) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data)==0 { return fmt.Errorf("Empty packet") }
+	switch data[0]>>4 {
+	case 4: return ip.DecodeType(layers.LayerTypeIPv4,data,df)
+	case 6: return ip.DecodeType(layers.LayerTypeIPv6,data,df)
+	}
+	if !isARP4(data) { return ENotNetwork }
+	return ip.DecodeType(layers.LayerTypeARP,data,df)
+}
+func isARP4(data []byte) bool {
+	if len(data)<6 { return false }
+	hrd := layers.LinkType(binary.BigEndian.Uint16(data))
+	pro := layers.EthernetType(binary.BigEndian.Uint16(data[2:]))
+	/* ARP hardware type 6 (IEEE 802) is gopacket's LinkTypeTokenRing. */
+	if hrd!=layers.LinkTypeEthernet && hrd!=layers.LinkTypeTokenRing { return false }
+	return pro==layers.EthernetTypeIPv4 && data[4]==6 && data[5]==4
+}
+func (ip *IPLayerPart) LayerType() gopacket.LayerType {
+	return LayerTypeIPLayerPart
+}
+func (ip *IPLayerPart) CanDecode() gopacket.LayerClass {
+	return ipLayerClass
+}
+func (ip *IPLayerPart) NextLayerType() gopacket.LayerType {
+	return ip.NextType
+}
+func decodeIPLayerPart(data []byte, p gopacket.PacketBuilder) error {
+	ip := new(IPLayerPart)
+	err := ip.DecodeFromBytes(data,p)
+	if err!=nil { return err }
+	p.AddLayer(ip)
+	if ip.IsAR { return nil }
+	return p.NextDecoder(ip.NextType)
+}
 func (ip *IPLayerPart) Flow() gopacket.Flow {
 	return ip.NetworkFlow
 }
 func (ip *IPLayerPart) PayloadType() gopacket.LayerType {
-	return ip.NextLayerType
+	return ip.NextType
 }
 func (ip *IPLayerPart) String() string {
-	return fmt.Sprintf("%v->%v (%v)",ip.SrcIP,ip.DstIP,ip.NextLayerType)
+	return fmt.Sprintf("%v->%v (%v)",ip.SrcIP,ip.DstIP,ip.NextType)
 }
 
 func (ip *IPLayerPart) decodeES6(df gopacket.DecodeFeedback) (err error) {
-	if !ip.ES6.CanDecode().Contains(ip.NextLayerType) { return }
+	if !ip.ES6.CanDecode().Contains(ip.NextType) { return }
 	payload := ip.Payload
 	ip.ES6.Payload = payload
 	lng := 0
-	for ip.ES6.CanDecode().Contains(ip.NextLayerType) {
+	for ip.ES6.CanDecode().Contains(ip.NextType) {
 		err = ip.ES6.DecodeFromBytes(ip.ES6.Payload,df)
 		if err!=nil { return }
+		/* The Next Header field is the first octet of each extension header. */
+		ip.NextHeaderOffset = len(ip.V6.Contents)+lng
+		ip.Protocol = ip.ES6.NextHeader
 		lng += len(ip.ES6.Contents)
-		ip.NextLayerType = ip.ES6.NextHeader.LayerType()
+		ip.NextType = ip.ES6.NextHeader.LayerType()
 	}
 	ip.ES6.Contents = payload[:lng]
 	
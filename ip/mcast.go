@@ -0,0 +1,128 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ip
+
+import "net"
+import "fmt"
+
+var ENotMulticast = fmt.Errorf("Not a multicast address")
+var ENotJoined = fmt.Errorf("Multicast group not joined")
+
+var (
+	/* RFC4291 2.7.1: All-Nodes, link-local scope. */
+	allNodes6 = Key6{0xff02000000000000,1}
+	/* RFC1112 4: All-Hosts. */
+	allHosts4 = Key4(0xe0000001)
+)
+
+func isMulticast4(k Key4) bool { return (k>>28)==0xe }
+
+func (i *IPHost) join6(k Key6) { i.M6[k]++ }
+func (i *IPHost) leave6(k Key6) {
+	if i.M6[k]>1 {
+		i.M6[k]--
+	} else {
+		delete(i.M6,k)
+	}
+}
+
+/*
+Joins a multicast group on this interface. Joins are counted, a group is left,
+after it has been left as often as joined.
+
+The All-Nodes group (ff02::1), the All-Hosts group (224.0.0.1) and the
+solicited-node groups of the IPv6 addresses are joined automatically. These
+memberships can not be left with LeaveGroup.
+*/
+func (i *IPHost) JoinGroup(g net.IP) error {
+	if g4 := g.To4(); g4!=nil {
+		var k Key4
+		k.Decode(g4)
+		if !isMulticast4(k) { return ENotMulticast }
+		i.Lock(); defer i.Unlock()
+		i.M4[k]++
+		i.j4[k]++
+		return nil
+	}
+	if len(g)!=16 || g[0]!=0xff { return ENotMulticast }
+	var k Key6
+	k.Decode(g)
+	i.Lock(); defer i.Unlock()
+	i.join6(k)
+	i.j6[k]++
+	return nil
+}
+
+/*
+Leaves a multicast group, joined with JoinGroup. Returns ENotJoined, if the
+group has not been joined with JoinGroup (or has already been left as often).
+*/
+func (i *IPHost) LeaveGroup(g net.IP) error {
+	if g4 := g.To4(); g4!=nil {
+		var k Key4
+		k.Decode(g4)
+		if !isMulticast4(k) { return ENotMulticast }
+		i.Lock(); defer i.Unlock()
+		if i.j4[k]==0 { return ENotJoined }
+		if i.j4[k]>1 { i.j4[k]-- } else { delete(i.j4,k) }
+		if i.M4[k]>1 {
+			i.M4[k]--
+		} else {
+			delete(i.M4,k)
+		}
+		return nil
+	}
+	if len(g)!=16 || g[0]!=0xff { return ENotMulticast }
+	var k Key6
+	k.Decode(g)
+	i.Lock(); defer i.Unlock()
+	if i.j6[k]==0 { return ENotJoined }
+	if i.j6[k]>1 { i.j6[k]-- } else { delete(i.j6,k) }
+	i.leave6(k)
+	return nil
+}
+
+// Reports, whether the group g is joined on this interface.
+func (i *IPHost) IsMember(g net.IP) bool {
+	i.RLock(); defer i.RUnlock()
+	if g4 := g.To4(); g4!=nil {
+		var k Key4
+		k.Decode(g4)
+		return i.M4[k]>0
+	}
+	if len(g)!=16 { return false }
+	var k Key6
+	k.Decode(g)
+	return i.M6[k]>0
+}
+
+// Returns the joined multicast groups.
+func (i *IPHost) Groups() []net.IP {
+	i.RLock(); defer i.RUnlock()
+	gs := make([]net.IP,0,len(i.M4)+len(i.M6))
+	for k := range i.M4 { gs = append(gs,k.IP()) }
+	for k := range i.M6 { gs = append(gs,k.IP()) }
+	return gs
+}
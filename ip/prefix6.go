@@ -0,0 +1,265 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ip
+
+import "net"
+import "time"
+
+/*
+Prefix (renumbering) events.
+
+Subscribers of an IPHost receive *PrefixEvent values through their Notify
+method, after the address events of the change.
+*/
+type PREFIX_EVENT uint8
+const (
+	PREFIX_ADDED = PREFIX_EVENT(iota+1) /* The prefix has been advertised. */
+	PREFIX_UPDATED    /* The lifetimes have been refreshed. */
+	PREFIX_DEPRECATED /* The preferred lifetime became 0 (renumbering). */
+	PREFIX_REMOVED    /* The prefix timed out or was withdrawn. */
+)
+
+func (e PREFIX_EVENT) String() string {
+	switch e {
+	case PREFIX_ADDED: return "added"
+	case PREFIX_UPDATED: return "updated"
+	case PREFIX_DEPRECATED: return "deprecated"
+	case PREFIX_REMOVED: return "removed"
+	}
+	return "?"
+}
+
+type PrefixEvent struct{
+	Event PREFIX_EVENT
+	Prefix net.IPNet
+	
+	/* The addresses, autoconfigured from the prefix. */
+	Addrs []net.IP
+}
+
+// Returns the prefix as net.IPNet.
+func (p IPv6Prefix) IPNet() net.IPNet {
+	return net.IPNet{IP:net.IP(append([]byte(nil),p.IP[:]...)),Mask:net.CIDRMask(int(p.Len),128)}
+}
+
+// Returns the addresses of a prefix. The IPHost must be locked.
+func (i *IPHost) prefixAddrs(pe *IPv6PrefixEntry) (as []*IPv6AddressEntry) {
+	pe.ListSync.Lock(); defer pe.ListSync.Unlock()
+	for e := pe.List.Front(); e!=nil; e = e.Next() {
+		if a,ok := i.V6[e.Value.(Key6)]; ok && a.Prefix==pe { as = append(as,a) }
+	}
+	return
+}
+
+// Returns the addresses of a prefix, as they are reported in a PrefixEvent.
+func (i *IPHost) prefixEvent(ev PREFIX_EVENT, pe *IPv6PrefixEntry) *PrefixEvent {
+	pev := &PrefixEvent{Event:ev,Prefix:pe.Prefix.IPNet()}
+	for _,a := range i.prefixAddrs(pe) { pev.Addrs = append(pev.Addrs,a.Unicast.IP()) }
+	return pev
+}
+
+func (i *IPHost) notifyPrefix(evs []*AddrEvent, pev *PrefixEvent) {
+	i.notify(evs)
+	if pev==nil { return }
+	for _,n := range i.events.get() { n.Notify(pev) }
+}
+
+// Links an address to the prefix, it was derived from.
+func (pe *IPv6PrefixEntry) own(a *IPv6AddressEntry) {
+	a.Prefix = pe
+	pe.ListSync.Lock(); defer pe.ListSync.Unlock()
+	pe.List.PushBack(a.Unicast)
+}
+
+// Unlinks an address from it's prefix.
+func (pe *IPv6PrefixEntry) disown(a *IPv6AddressEntry) {
+	pe.ListSync.Lock(); defer pe.ListSync.Unlock()
+	for e := pe.List.Front(); e!=nil; e = e.Next() {
+		if e.Value.(Key6)==a.Unicast { pe.List.Remove(e); return }
+	}
+}
+
+/*
+Removes a (locked) prefix. It's addresses are deprecated immediately and
+removed, when their valid lifetime expires (at most two hours, RFC4862 5.5.3
+(e)).
+*/
+func (i *IPHost) withdrawPrefix(pe *IPv6PrefixEntry, NOW time.Time, evs []*AddrEvent) ([]*AddrEvent, *PrefixEvent) {
+	if i.Prefix6[pe.Prefix]==pe { delete(i.Prefix6,pe.Prefix) }
+	pev := i.prefixEvent(PREFIX_REMOVED,pe)
+	evs = i.updateLifetimes(pe,0,0,NOW,evs)
+	return evs,pev
+}
+
+/*
+Processes a Prefix Information option (RFC4861 6.3.4, RFC4862 5.5.3).
+
+With the on-link flag set, the prefix is added to, refreshed in or removed from
+the Prefix List. Options without it leave the Prefix List untouched.
+
+With the autonomous flag set and iid (the 64 bit interface identifier) given, a
+tentative address is formed from a new /64 prefix. The addresses of an existing
+prefix get it's new lifetimes (see UpdatePrefixLifetimes).
+*/
+func (i *IPHost) PrefixInformation(p IPv6Prefix, valid, preferred uint32, onlink, autonomous bool, iid []byte, NOW time.Time) {
+	var evs []*AddrEvent
+	var pev *PrefixEvent
+	i.Lock()
+	pe := i.Prefix6[p]
+	if onlink {
+		switch {
+		case pe==nil && valid==0:
+			/*
+			 * - If the Prefix Information option's Valid Lifetime field is zero,
+			 *   and the prefix is not present in the host's Prefix List,
+			 *   silently ignore the option.
+			 */
+		case pe==nil:
+			/*
+			 * - If the prefix is not already present in the Prefix List, and the
+			 *   Prefix Information option's Valid Lifetime field is non-zero,
+			 *   create a new entry for the prefix and initialize its
+			 *   invalidation timer to the Valid Lifetime value in the Prefix
+			 *   Information option.
+			 */
+			pe = new(IPv6PrefixEntry)
+			pe.List.Init()
+			pe.Prefix = p
+			pe.Onlink = true
+			i.Prefix6[p] = pe
+			
+			/*
+			 * Adopt the addresses of a previous incarnation of the prefix, and
+			 * those autoconfigured, while it was not in the Prefix List.
+			 */
+			for _,a := range i.V6 {
+				switch {
+				case a.Prefix!=nil && a.Prefix!=pe && a.Prefix.Prefix==p:
+				case a.Prefix==nil && a.Autoconf && p.Match(a.Unicast.IP()):
+				default: continue
+				}
+				if a.Prefix!=nil { a.Prefix.disown(a) }
+				pe.own(a)
+			}
+			pev = &PrefixEvent{Event:PREFIX_ADDED}
+		case valid==0:
+			/*
+			 * If the new Lifetime value is zero, time-out the prefix
+			 * immediately.
+			 */
+			evs,pev = i.withdrawPrefix(pe,NOW,evs)
+			i.Unlock()
+			i.notifyPrefix(evs,pev)
+			return
+		case preferred==0 && pe.PreferredLifetime!=0:
+			pev = &PrefixEvent{Event:PREFIX_DEPRECATED}
+		default:
+			pev = &PrefixEvent{Event:PREFIX_UPDATED}
+		}
+		if pe!=nil {
+			pe.Lifetime = valid
+			pe.PreferredLifetime = preferred
+			pe.Tstamp = NOW
+			pe.Slaac = autonomous
+		}
+	}
+	
+	if autonomous {
+		if pe!=nil { pe.Slaac = true }
+		/*
+		 * RFC4862 5.5.3 (d):
+		 *   If the prefix advertised is not equal to the prefix of an
+		 *   address configured by stateless autoconfiguration already in
+		 *   the list of addresses associated with the interface, and if
+		 *   the Valid Lifetime is not 0, form an address by combining the
+		 *   advertised prefix with an interface identifier.
+		 *
+		 *   If the sum of the prefix length and interface identifier
+		 *   length does not equal 128 bits, the Prefix Information
+		 *   option MUST be ignored.
+		 */
+		var k Key6
+		if len(iid)==8 && p.Len==64 {
+			addr := make(net.IP,16)
+			copy(addr,p.IP[:8])
+			copy(addr[8:],iid)
+			k.Decode(addr)
+		}
+		switch {
+		case pe!=nil && len(i.prefixAddrs(pe))!=0:
+			evs = i.updateLifetimes(pe,valid,preferred,NOW,evs)
+		case len(iid)!=8 || p.Len!=64:
+		case i.V6[k]!=nil:
+			/*
+			 * An address autoconfigured, while the prefix was not in the Prefix
+			 * List (or from a previous incarnation of it).
+			 */
+			a := i.V6[k]
+			if !a.Autoconf { break }
+			evs = a.updateLifetimes(valid,preferred,NOW,evs)
+			if pe!=nil && a.Prefix!=pe {
+				if a.Prefix!=nil { a.Prefix.disown(a) }
+				pe.own(a)
+			}
+		case valid!=0:
+			var a *IPv6AddressEntry
+			a,evs = i.insertIP6(k,true,false,valid,preferred,NOW,evs)
+			a.Autoconf = true
+			if pe!=nil { pe.own(a) }
+		}
+	}
+	if pev!=nil { pev = i.prefixEvent(pev.Event,pe) }
+	i.Unlock()
+	i.notifyPrefix(evs,pev)
+}
+
+/*
+Runs the invalidation timers of the prefixes. Expired prefixes are removed, like
+withdrawn ones.
+*/
+func (i *IPHost) prefixTimer(NOW time.Time) {
+	var pevs []*PrefixEvent
+	var evs []*AddrEvent
+	i.Lock()
+	for _,pe := range i.Prefix6 {
+		if !lifetimeExpired(pe.Lifetime,NOW.Sub(pe.Tstamp)) { continue }
+		var pev *PrefixEvent
+		evs,pev = i.withdrawPrefix(pe,NOW,evs)
+		pevs = append(pevs,pev)
+	}
+	i.Unlock()
+	i.notify(evs)
+	for _,pev := range pevs { i.notifyPrefix(nil,pev) }
+}
+
+// Returns the prefix list.
+func (i *IPHost) Prefixes() []IPv6PrefixEntry {
+	i.RLock(); defer i.RUnlock()
+	ps := make([]IPv6PrefixEntry,0,len(i.Prefix6))
+	for _,pe := range i.Prefix6 {
+		ps = append(ps,IPv6PrefixEntry{Prefix:pe.Prefix,Lifetime:pe.Lifetime,PreferredLifetime:pe.PreferredLifetime,Tstamp:pe.Tstamp,Onlink:pe.Onlink,Slaac:pe.Slaac})
+	}
+	return ps
+}
@@ -0,0 +1,279 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ip
+
+import "net"
+import "bytes"
+
+/*
+Address scopes (RFC 4291 2.7, RFC 6724 3.1). The scope of a multicast address
+is it's scope field.
+*/
+const (
+	SCOPE_INTERFACE = 0x1
+	SCOPE_LINK      = 0x2
+	SCOPE_ADMIN     = 0x4
+	SCOPE_SITE      = 0x5
+	SCOPE_ORG       = 0x8
+	SCOPE_GLOBAL    = 0xe
+)
+
+/*
+Returns the scope of an address.
+
+RFC 6724 3.2:
+  IPv4 addresses are treated as IPv4-mapped IPv6 addresses. Auto-configuration
+  addresses (169.254/16) and loopback addresses (127/8) are assigned link-local
+  scope, all others global scope.
+*/
+func Scope(ip net.IP) int {
+	if i4 := ip.To4(); i4!=nil {
+		if i4[0]==127 || (i4[0]==169 && i4[1]==254) { return SCOPE_LINK }
+		return SCOPE_GLOBAL
+	}
+	if len(ip)!=16 { return SCOPE_GLOBAL }
+	switch {
+	case ip[0]==0xff: return int(ip[1]&0xf)
+	case ip[0]==0xfe && (ip[1]&0xc0)==0x80: return SCOPE_LINK
+	case ip[0]==0xfe && (ip[1]&0xc0)==0xc0: return SCOPE_SITE
+	case ip.Equal(net.IPv6loopback): return SCOPE_LINK
+	}
+	return SCOPE_GLOBAL
+}
+
+// Reports, whether ip (in 16 byte form) is within the prefix.
+func (p *IPv6Prefix) Match(ip net.IP) bool {
+	ip = ip.To16()
+	if ip==nil { return false }
+	eq := byte(0)
+	lng := int(p.Len)
+	hi := lng>>3
+	lo := lng&7
+	for i:=0 ; i<hi; i++ { eq |= p.IP[i]^ip[i] }
+	if lo!=0 {   eq |= (p.IP[hi]^ip[hi]) & bitbytes[lo]   }
+	return eq==0
+}
+
+/*
+RFC 6724 2.1. Policy Table
+*/
+type PolicyEntry struct{
+	Prefix IPv6Prefix
+	Precedence uint8
+	Label uint8
+}
+
+/*
+A policy table, looked up by longest prefix match. IPv4 addresses are looked
+up as IPv4-mapped addresses.
+*/
+type PolicyTable []PolicyEntry
+
+func policy(s string, prec, label uint8) (e PolicyEntry) {
+	_,n,err := net.ParseCIDR(s)
+	if err!=nil { panic(err) }
+	ones,_ := n.Mask.Size()
+	copy(e.Prefix.IP[:],n.IP.To16())
+	e.Prefix.Len = uint8(ones)
+	e.Precedence = prec
+	e.Label = label
+	return
+}
+
+/*
+RFC 6724 2.1:
+      Prefix        Precedence Label
+      ::1/128               50     0
+      ::/0                  40     1
+      ::ffff:0:0/96         35     4
+      2002::/16             30     2
+      2001::/32              5     5
+      fc00::/7               3    13
+      ::/96                  1     3
+      fec0::/10              1    11
+      3ffe::/16              1    12
+*/
+var DefaultPolicyTable = PolicyTable{
+	policy("::1/128",50,0),
+	policy("::/0",40,1),
+	policy("::ffff:0:0/96",35,4),
+	policy("2002::/16",30,2),
+	policy("2001::/32",5,5),
+	policy("fc00::/7",3,13),
+	policy("::/96",1,3),
+	policy("fec0::/10",1,11),
+	policy("3ffe::/16",1,12),
+}
+
+// Returns the entry with the longest matching prefix, or nil.
+func (t PolicyTable) Lookup(ip net.IP) *PolicyEntry {
+	var best *PolicyEntry
+	for i := range t {
+		if !t[i].Prefix.Match(ip) { continue }
+		if best==nil || t[i].Prefix.Len>best.Prefix.Len { best = &t[i] }
+	}
+	return best
+}
+
+// Returns Label(ip). Addresses not in the table get the label 0xff.
+func (t PolicyTable) Label(ip net.IP) uint8 {
+	if e := t.Lookup(ip); e!=nil { return e.Label }
+	return 0xff
+}
+
+// Returns Precedence(ip). Addresses not in the table get the precedence 0.
+func (t PolicyTable) Precedence(ip net.IP) uint8 {
+	if e := t.Lookup(ip); e!=nil { return e.Precedence }
+	return 0
+}
+
+// Returns the policy table in effect (DefaultPolicyTable, if Policy is nil).
+func (i *IPHost) PolicyTable() PolicyTable {
+	if i.Policy==nil { return DefaultPolicyTable }
+	return i.Policy
+}
+
+type srcCandidate6 struct{
+	ip net.IP
+	addr *IPv6AddressEntry
+	scope int
+	label uint8
+}
+
+/*
+RFC 6724 5. Source Address Selection
+
+Reports, whether SA is preferred over SB for the destination D.
+*/
+func (i *IPHost) preferSource6(a, b *srcCandidate6, dst net.IP, sd int, ld uint8) bool {
+	/* Rule 1: Prefer same address. */
+	if a.ip.Equal(dst) { return true }
+	if b.ip.Equal(dst) { return false }
+	
+	/*
+	 * Rule 2: Prefer appropriate scope.
+	 *   If Scope(SA) < Scope(SB): If Scope(SA) < Scope(D), then prefer SB and
+	 *   otherwise prefer SA.  Similarly, if Scope(SB) < Scope(SA): If
+	 *   Scope(SB) < Scope(D), then prefer SA and otherwise prefer SB.
+	 */
+	if a.scope<b.scope { return a.scope>=sd }
+	if b.scope<a.scope { return b.scope<sd }
+	
+	/* Rule 3: Avoid deprecated addresses. */
+	da,db := a.addr.State==ADDR_DEPRECATED,b.addr.State==ADDR_DEPRECATED
+	if da!=db { return db }
+	
+	/* Rule 4: Prefer home addresses. */
+	if a.addr.Home!=b.addr.Home { return a.addr.Home }
+	
+	/*
+	 * Rule 5: Prefer outgoing interface.
+	 *   An IPHost holds the addresses of one interface, so all candidates are
+	 *   assigned to the outgoing interface.
+	 */
+	
+	/* Rule 6: Prefer matching label. */
+	if (a.label==ld)!=(b.label==ld) { return a.label==ld }
+	
+	/*
+	 * Rule 7: Prefer temporary addresses.
+	 *   The default SHOULD prefer temporary addresses (unless PreferPublic is
+	 *   set).
+	 */
+	if a.addr.Temporary!=b.addr.Temporary { return a.addr.Temporary!=i.PreferPublic }
+	
+	/* Rule 8: Use longest matching prefix. */
+	if c := LongestPrefixV6(a.ip,b.ip,dst); c!=0 { return c<0 }
+	
+	/* Otherwise, the result is made deterministic. */
+	return bytes.Compare(a.ip,b.ip)<0
+}
+
+func (i *IPHost) selectSource6(dst net.IP) net.IP {
+	pt := i.PolicyTable()
+	sd := Scope(dst)
+	ld := pt.Label(dst)
+	i.RLock(); defer i.RUnlock()
+	var best,cur srcCandidate6
+	for k,addr := range i.V6 {
+		/*
+		 * RFC 6724 4:
+		 *   The candidate source addresses MUST NOT include addresses that
+		 *   are not in the preferred or deprecated state (tentative ones).
+		 *
+		 * Anycast addresses are never used as source address.
+		 */
+		if !addr.Assigned() || addr.Anycast { continue }
+		cur.ip = k.IP()
+		cur.addr = addr
+		cur.scope = Scope(cur.ip)
+		cur.label = pt.Label(cur.ip)
+		if best.ip==nil || i.preferSource6(&cur,&best,dst,sd,ld) { best = cur }
+	}
+	return best.ip
+}
+
+/*
+IPv4 source address selection, treating the addresses as IPv4-mapped
+addresses (RFC 6724 3.2). The labels of all IPv4 addresses are equal.
+Addresses, whose subnet contains the destination, are preferred, as the
+next hop is on-link through them.
+*/
+func (i *IPHost) preferSource4(a, b *IPv4AddressEntry, d Key4, sd int) bool {
+	/* Rule 1: Prefer same address. */
+	if a.Addr==d { return true }
+	if b.Addr==d { return false }
+	
+	/* Rule 2: Prefer appropriate scope. */
+	sa,sb := Scope(a.Addr.IP()),Scope(b.Addr.IP())
+	if sa<sb { return sa>=sd }
+	if sb<sa { return sb<sd }
+	
+	/* Prefer on-link subnet. */
+	ona := (d&a.Subnetmask)==(a.Addr&a.Subnetmask)
+	onb := (d&b.Subnetmask)==(b.Addr&b.Subnetmask)
+	if ona!=onb { return ona }
+	
+	/* Prefer primary addresses. */
+	if a.Secondary!=b.Secondary { return b.Secondary }
+	
+	/* Rule 8: Use longest matching prefix. */
+	if c := leadzeroCmp(uint64(a.Addr^d)<<32,uint64(b.Addr^d)<<32); c!=0 { return c<0 }
+	
+	return a.Addr<b.Addr
+}
+
+func (i *IPHost) selectSource4(d4 net.IP) net.IP {
+	var k4 Key4
+	k4.Decode(d4)
+	sd := Scope(d4)
+	i.RLock(); defer i.RUnlock()
+	var best *IPv4AddressEntry
+	for _,addr := range i.V4 {
+		if best==nil || i.preferSource4(addr,best,k4,sd) { best = addr }
+	}
+	if best==nil { return nil }
+	return best.Addr.IP()
+}
@@ -27,7 +27,10 @@ SOFTWARE.
 package ip
 
 import "encoding/binary"
+import "math/bits"
 import "net"
+import "strings"
+import "fmt"
 
 type Key4 uint32
 func (k Key4) IP() net.IP {
@@ -70,6 +73,21 @@ func LongestPrefixV6(a,b, pivot net.IP) int {
 	return leadzeroCmp(A,B)
 }
 
+/*
+RFC 6724 2.2:
+  CommonPrefixLen(S, D), is defined as the length of the longest prefix
+  (looking at the most significant, or leftmost, bits) that the two addresses
+  have in common, up to the length of S's prefix.
+
+The addresses must be 16 bytes long, plen is the length of S's prefix.
+*/
+func commonPrefixLen(s, d net.IP, plen int) int {
+	n := bits.LeadingZeros64(binary.BigEndian.Uint64([]byte(s))^binary.BigEndian.Uint64([]byte(d)))
+	if n==64 { n += bits.LeadingZeros64(binary.BigEndian.Uint64([]byte(s)[8:])^binary.BigEndian.Uint64([]byte(d)[8:])) }
+	if n>plen { n = plen }
+	return n
+}
+
 /*
 a==b =  0
 a<b  = -1
@@ -120,3 +138,21 @@ func leadzeroCmp(a,b uint64) int {
 }
 
 
+// Returns true for IPv4 (169.254.0.0/16) and IPv6 (fe80::/10) link-local unicast addresses.
+func IsLinkLocal(i net.IP) bool {
+	if i4 := i.To4(); i4!=nil { return i4[0]==169 && i4[1]==254 }
+	if len(i)!=16 { return false }
+	return i[0]==0xfe && (i[1]&0xc0)==0x80
+}
+
+// Parses an IP address with an optional zone, like "fe80::1%tap1".
+func ParseZoned(s string) (*net.IPAddr, error) {
+	addr := new(net.IPAddr)
+	if p := strings.LastIndexByte(s,'%'); p>=0 {
+		s,addr.Zone = s[:p],s[p+1:]
+	}
+	addr.IP = net.ParseIP(s)
+	if addr.IP==nil { return nil,fmt.Errorf("Invalid address %q",s) }
+	if i4 := addr.IP.To4(); i4!=nil { addr.IP = i4 }
+	return addr,nil
+}
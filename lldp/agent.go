@@ -0,0 +1,235 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package lldp
+
+import "github.com/maxymania/ipsolution/icmp"
+import "github.com/maxymania/ipsolution/eth"
+import "github.com/maxymania/ipsolution/ip"
+import "github.com/google/gopacket"
+import "github.com/google/gopacket/layers"
+
+import "sort"
+import "sync"
+import "time"
+import "fmt"
+import "net"
+import "os"
+
+/*
+ * A neighbor, discovered by an Agent. It is identified by it's ChassisID and
+ * PortID (the MSAP identifier) and expires after the TTL of it's last LLDPDU.
+ */
+type Neighbor struct{
+	LLDPDU
+	SrcMAC net.HardwareAddr
+	Expires time.Time
+}
+func (n *Neighbor) String() string {
+	return fmt.Sprintf("chassis %s port %s name %q from %v ttl %v",formatChassisID(n.ChassisID),formatPortID(n.PortID),n.SysName,n.SrcMAC,time.Until(n.Expires).Truncate(time.Second))
+}
+
+/*
+ * An LLDP agent of an interface. It announces the Host, and maintains a table
+ * of the neighbors, it has received LLDPDUs from.
+ *
+ * The Chassis ID is the Host's Mac, the Port ID is the Host's Name. The
+ * Host's IP addresses are announced as management addresses.
+ */
+type Agent struct{
+	Host *icmp.Host
+	SysName string /* Defaults to the host name. */
+	SysDescription string
+	PortDescription string
+	Capabilities uint16 /* Defaults to CAP_STATION. */
+	
+	/* IEEE 802.1AB 9.2.5.7: msgTxInterval (default: 30s) and msgTxHold (default: 4). */
+	TxInterval time.Duration
+	TxHold uint16
+	
+	/* Maximum size of the neighbor table. LLDPDUs of further neighbors are ignored. */
+	MaxNeighbors int
+	
+	lastTx time.Time
+	neighbors map[string]*Neighbor
+	mutex sync.Mutex
+}
+
+/*
+ * Initializes the agent. The Host must be set, it joins the LLDP multicast
+ * address and the agent is registered as it's LLDP handler.
+ */
+func (a *Agent) Init() *Agent {
+	if a.SysName=="" { a.SysName,_ = os.Hostname() }
+	if a.Capabilities==0 { a.Capabilities = CAP_STATION }
+	if a.TxInterval==0 { a.TxInterval = 30*time.Second }
+	if a.TxHold==0 { a.TxHold = 4 }
+	if a.MaxNeighbors==0 { a.MaxNeighbors = 64 }
+	a.neighbors = make(map[string]*Neighbor)
+	a.Host.Filter.Join(MulticastMAC)
+	a.Host.EtherTypes.Register(EthernetTypeLLDP,a)
+	return a
+}
+
+/* IEEE 802.1AB 9.2.5.22: txTTL = min(65535, (msgTxInterval * msgTxHold) + 1) */
+func (a *Agent) ttl() uint16 {
+	t := int64(a.TxInterval/time.Second)*int64(a.TxHold)+1
+	if t>0xffff { return 0xffff }
+	return uint16(t)
+}
+
+func mgmtAddress(addr net.IP, ifindex int) (m layers.LLDPMgmtAddress) {
+	m.InterfaceSubtype = layers.LLDPInterfaceSubtypeifIndex
+	m.InterfaceNumber = uint32(ifindex)
+	if a4 := addr.To4(); a4!=nil {
+		m.Subtype = layers.IANAAddressFamilyIPV4
+		m.Address = a4
+	} else {
+		m.Subtype = layers.IANAAddressFamilyIPV6
+		m.Address = addr.To16()
+	}
+	return
+}
+
+/* Returns the LLDPDU, the agent sends. */
+func (a *Agent) LLDPDU() *LLDPDU {
+	h := a.Host
+	d := &LLDPDU{
+		ChassisID: layers.LLDPChassisID{layers.LLDPChassisIDSubTypeMACAddr,h.Mac},
+		PortID: layers.LLDPPortID{layers.LLDPPortIDSubtypeIfaceName,[]byte(h.Name)},
+		TTL: a.ttl(),
+		PortDescription: a.PortDescription,
+		SysName: a.SysName,
+		SysDescription: a.SysDescription,
+		SysCapabilities: a.Capabilities,
+		EnabledCapabilities: a.Capabilities,
+	}
+	if h.Name=="" {
+		d.PortID = layers.LLDPPortID{layers.LLDPPortIDSubtypeMACAddr,h.Mac}
+	}
+	if h.Host!=nil {
+		/* Link-local addresses are only announced, if there is nothing else. */
+		var ll []layers.LLDPMgmtAddress
+		for _,addr := range h.Host.Addrs() {
+			if ip.IsLinkLocal(addr.IP) {
+				ll = append(ll,mgmtAddress(addr.IP,h.Index))
+			} else {
+				d.MgmtAddresses = append(d.MgmtAddresses,mgmtAddress(addr.IP,h.Index))
+			}
+		}
+		if len(d.MgmtAddresses)==0 { d.MgmtAddresses = ll }
+	}
+	return d
+}
+
+func (a *Agent) send(d *LLDPDU, po icmp.PacketOutput) error {
+	var e eth.EthLayer2
+	e.SrcMAC = a.Host.Mac
+	e.DstMAC = MulticastMAC
+	e.EthernetType = EthernetTypeLLDP
+	b := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(b,gopacket.SerializeOptions{true,true},&e,gopacket.Payload(d.Marshal()))
+	if err!=nil { return err }
+	return po.WritePacketData(b.Bytes())
+}
+
+/* Sends an LLDPDU. */
+func (a *Agent) Send(po icmp.PacketOutput) error {
+	a.mutex.Lock()
+	a.lastTx = time.Now()
+	a.mutex.Unlock()
+	return a.send(a.LLDPDU(),po)
+}
+
+/*
+ * Sends a shutdown LLDPDU (TTL 0), which removes us from the neighbor's
+ * tables (IEEE 802.1AB 9.1.2.1).
+ */
+func (a *Agent) Shutdown(po icmp.PacketOutput) error {
+	d := a.LLDPDU()
+	d.TTL = 0
+	d.MgmtAddresses = nil
+	d.PortDescription,d.SysName,d.SysDescription,d.SysCapabilities = "","","",0
+	return a.send(d,po)
+}
+
+func msapID(d *LLDPDU) string {
+	return fmt.Sprintf("%d:%x/%d:%x",d.ChassisID.Subtype,d.ChassisID.ID,d.PortID.Subtype,d.PortID.ID)
+}
+
+/*
+ * Processes a received LLDP frame. Implements icmp.FrameHandler.
+ */
+func (a *Agent) InputFrame(e *eth.EthLayer2, po icmp.PacketOutput) error {
+	if e.EthernetType!=EthernetTypeLLDP { return icmp.ENotSupp }
+	n := new(Neighbor)
+	/* The frame's buffer may be reused, so the LLDPDU is decoded from a copy. */
+	err := n.Unmarshal(append([]byte(nil),e.Payload...))
+	if err!=nil { return err }
+	n.SrcMAC = append(net.HardwareAddr(nil),e.SrcMAC...)
+	n.Expires = time.Now().Add(time.Duration(n.TTL)*time.Second)
+	
+	k := msapID(&n.LLDPDU)
+	a.mutex.Lock(); defer a.mutex.Unlock()
+	if n.TTL==0 {
+		delete(a.neighbors,k)
+		return nil
+	}
+	if _,ok := a.neighbors[k]; !ok && len(a.neighbors)>=a.MaxNeighbors { return nil }
+	a.neighbors[k] = n
+	return nil
+}
+
+/* Implements icmp.EtherTypeHandler. */
+func (a *Agent) HandleFrame(e *eth.EthLayer2, w *icmp.FrameWriter) error {
+	return a.InputFrame(e,w)
+}
+
+/*
+ * Removes expired neighbors and sends an LLDPDU through the Host's Output
+ * every TxInterval.
+ */
+func (a *Agent) TimerEvent(NOW time.Time) {
+	a.mutex.Lock()
+	for k,n := range a.neighbors {
+		if NOW.After(n.Expires) { delete(a.neighbors,k) }
+	}
+	due := NOW.Sub(a.lastTx)>=a.TxInterval
+	a.mutex.Unlock()
+	if due && a.Host.Output!=nil { a.Send(a.Host.Output) }
+}
+
+/* Returns the discovered neighbors, ordered by chassis and port. */
+func (a *Agent) Neighbors() []Neighbor {
+	NOW := time.Now()
+	a.mutex.Lock()
+	r := make([]Neighbor,0,len(a.neighbors))
+	for _,n := range a.neighbors {
+		if NOW.After(n.Expires) { continue }
+		r = append(r,*n)
+	}
+	a.mutex.Unlock()
+	sort.Slice(r,func(i,j int) bool { return msapID(&r[i].LLDPDU)<msapID(&r[j].LLDPDU) })
+	return r
+}
@@ -0,0 +1,222 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+/*
+Link Layer Discovery Protocol (IEEE 802.1AB).
+*/
+package lldp
+
+import "github.com/google/gopacket/layers"
+import "encoding/binary"
+import "net"
+import "fmt"
+import "unicode/utf8"
+
+/* The nearest-bridge group address, LLDPDUs are sent to. */
+var MulticastMAC = net.HardwareAddr{0x01,0x80,0xc2,0x00,0x00,0x0e}
+
+const EthernetTypeLLDP = layers.EthernetTypeLinkLayerDiscovery
+
+/* System capabilities (IEEE 802.1AB 8.5.8.1). */
+const (
+	CAP_OTHER    uint16 = 1<<0
+	CAP_REPEATER uint16 = 1<<1
+	CAP_BRIDGE   uint16 = 1<<2
+	CAP_WLANAP   uint16 = 1<<3
+	CAP_ROUTER   uint16 = 1<<4
+	CAP_PHONE    uint16 = 1<<5
+	CAP_DOCSIS   uint16 = 1<<6
+	CAP_STATION  uint16 = 1<<7
+)
+
+var EInvalid = fmt.Errorf("Malformed LLDPDU")
+
+/*
+An LLDPDU. ChassisID, PortID and TTL are mandatory. The system capabilities
+TLV is only present, if SysCapabilities is not 0. Unknown and organizationally
+specific TLVs are kept in Other.
+*/
+type LLDPDU struct{
+	ChassisID layers.LLDPChassisID
+	PortID layers.LLDPPortID
+	TTL uint16
+	PortDescription string
+	SysName string
+	SysDescription string
+	SysCapabilities uint16
+	EnabledCapabilities uint16
+	MgmtAddresses []layers.LLDPMgmtAddress
+	Other []layers.LinkLayerDiscoveryValue
+}
+
+/* The length of a TLV's value is a 9 bit field. */
+const maxTLVLen = 0x1ff
+
+/*
+IEEE 802.1AB 8.5: The Chassis ID and Port ID (after the subtype) and the
+string TLVs are at most 255 octets long.
+*/
+const maxStringLen = 255
+
+func appendTLV(b []byte, t layers.LLDPTLVType, v []byte) []byte {
+	if len(v)>maxTLVLen { v = v[:maxTLVLen] }
+	h := uint16(t)<<9|uint16(len(v))
+	b = append(b,byte(h>>8),byte(h))
+	return append(b,v...)
+}
+
+/* Truncates s to n bytes, without splitting an UTF-8 sequence. */
+func truncateString(s string, n int) string {
+	if len(s)<=n { return s }
+	for n>0 && !utf8.RuneStart(s[n]) { n-- }
+	return s[:n]
+}
+func truncateID(id []byte) []byte {
+	if len(id)>maxStringLen { return id[:maxStringLen] }
+	return id
+}
+func appendStringTLV(b []byte, t layers.LLDPTLVType, s string) []byte {
+	if s=="" { return b }
+	return appendTLV(b,t,[]byte(truncateString(s,maxStringLen)))
+}
+
+/*
+Encodes the LLDPDU, including the End Of LLDPDU TLV. Values exceeding the
+maximum length of their TLV are truncated.
+*/
+func (d *LLDPDU) Marshal() []byte {
+	b := make([]byte,0,128)
+	b = appendTLV(b,layers.LLDPTLVChassisID,append([]byte{byte(d.ChassisID.Subtype)},truncateID(d.ChassisID.ID)...))
+	b = appendTLV(b,layers.LLDPTLVPortID,append([]byte{byte(d.PortID.Subtype)},truncateID(d.PortID.ID)...))
+	b = appendTLV(b,layers.LLDPTLVTTL,[]byte{byte(d.TTL>>8),byte(d.TTL)})
+	b = appendStringTLV(b,layers.LLDPTLVPortDescription,d.PortDescription)
+	b = appendStringTLV(b,layers.LLDPTLVSysName,d.SysName)
+	b = appendStringTLV(b,layers.LLDPTLVSysDescription,d.SysDescription)
+	if d.SysCapabilities!=0 {
+		var v [4]byte
+		binary.BigEndian.PutUint16(v[:],d.SysCapabilities)
+		binary.BigEndian.PutUint16(v[2:],d.EnabledCapabilities)
+		b = appendTLV(b,layers.LLDPTLVSysCapabilities,v[:])
+	}
+	for _,m := range d.MgmtAddresses {
+		/* IEEE 802.1AB 8.5.9 */
+		v := make([]byte,0,12+len(m.Address)+len(m.OID))
+		v = append(v,byte(len(m.Address)+1),byte(m.Subtype))
+		v = append(v,m.Address...)
+		v = append(v,byte(m.InterfaceSubtype),0,0,0,0)
+		binary.BigEndian.PutUint32(v[len(v)-4:],m.InterfaceNumber)
+		v = append(v,byte(len(m.OID)))
+		v = append(v,m.OID...)
+		b = appendTLV(b,layers.LLDPTLVMgmtAddress,v)
+	}
+	for _,o := range d.Other {
+		b = appendTLV(b,o.Type,o.Value)
+	}
+	return appendTLV(b,layers.LLDPTLVEnd,nil)
+}
+
+/* Decodes an LLDPDU. The slices of d refer to data. */
+func (d *LLDPDU) Unmarshal(data []byte) error {
+	*d = LLDPDU{}
+	for i := 0; len(data)>0; i++ {
+		if len(data)<2 { return EInvalid }
+		h := binary.BigEndian.Uint16(data)
+		t := layers.LLDPTLVType(h>>9)
+		l := int(h&0x1ff)
+		if len(data)<2+l { return EInvalid }
+		v := data[2:2+l]
+		data = data[2+l:]
+		
+		/* IEEE 802.1AB 9.2.7.7.1: The first three TLVs must be Chassis ID, Port ID and TTL. */
+		if i<3 && t!=layers.LLDPTLVType(i+1) { return EInvalid }
+		switch t {
+		case layers.LLDPTLVEnd:
+			return nil
+		case layers.LLDPTLVChassisID:
+			if l<2 { return EInvalid }
+			d.ChassisID = layers.LLDPChassisID{layers.LLDPChassisIDSubType(v[0]),v[1:]}
+		case layers.LLDPTLVPortID:
+			if l<2 { return EInvalid }
+			d.PortID = layers.LLDPPortID{layers.LLDPPortIDSubType(v[0]),v[1:]}
+		case layers.LLDPTLVTTL:
+			if l<2 { return EInvalid }
+			d.TTL = binary.BigEndian.Uint16(v)
+		case layers.LLDPTLVPortDescription:
+			d.PortDescription = string(v)
+		case layers.LLDPTLVSysName:
+			d.SysName = string(v)
+		case layers.LLDPTLVSysDescription:
+			d.SysDescription = string(v)
+		case layers.LLDPTLVSysCapabilities:
+			if l<4 { return EInvalid }
+			d.SysCapabilities = binary.BigEndian.Uint16(v)
+			d.EnabledCapabilities = binary.BigEndian.Uint16(v[2:])
+		case layers.LLDPTLVMgmtAddress:
+			if l<1 { return EInvalid }
+			al := int(v[0])
+			if al<1 || l<al+7 { return EInvalid }
+			var m layers.LLDPMgmtAddress
+			m.Subtype = layers.IANAAddressFamily(v[1])
+			m.Address = v[2:1+al]
+			m.InterfaceSubtype = layers.LLDPInterfaceSubtype(v[1+al])
+			m.InterfaceNumber = binary.BigEndian.Uint32(v[2+al:])
+			ol := int(v[6+al])
+			if l<al+7+ol { return EInvalid }
+			m.OID = string(v[7+al:7+al+ol])
+			d.MgmtAddresses = append(d.MgmtAddresses,m)
+		default:
+			d.Other = append(d.Other,layers.LinkLayerDiscoveryValue{t,uint16(l),v})
+		}
+	}
+	if d.ChassisID.ID==nil { return EInvalid }
+	return nil
+}
+
+/* Returns the management addresses, that are IP addresses. */
+func (d *LLDPDU) IPAddresses() (r []net.IP) {
+	for _,m := range d.MgmtAddresses {
+		switch m.Subtype {
+		case layers.IANAAddressFamilyIPV4,layers.IANAAddressFamilyIPV6:
+			r = append(r,net.IP(m.Address))
+		}
+	}
+	return
+}
+
+func formatChassisID(c layers.LLDPChassisID) string {
+	switch c.Subtype {
+	case layers.LLDPChassisIDSubTypeMACAddr: return net.HardwareAddr(c.ID).String()
+	case layers.LLDPChassisIDSubTypeNetworkAddr:
+		if len(c.ID)>1 { return net.IP(c.ID[1:]).String() }
+	}
+	return string(c.ID)
+}
+func formatPortID(p layers.LLDPPortID) string {
+	switch p.Subtype {
+	case layers.LLDPPortIDSubtypeMACAddr: return net.HardwareAddr(p.ID).String()
+	case layers.LLDPPortIDSubtypeNetworkAddr:
+		if len(p.ID)>1 { return net.IP(p.ID[1:]).String() }
+	}
+	return string(p.ID)
+}
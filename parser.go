@@ -0,0 +1,70 @@
+/*
+MIT License
+
+Copyright (c) 2017 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package ipsolution
+
+import "github.com/maxymania/ipsolution/eth"
+import "github.com/maxymania/ipsolution/ip"
+import "github.com/google/gopacket"
+import "github.com/google/gopacket/layers"
+
+/*
+A reusable parser chain for Ethernet frames: EthLayer2 (with VLAN tags and
+LLC/SNAP), IPLayerPart (IPv4, IPv6 and ARP), ICMPv4 or ICMPv6 and the Payload.
+Decoding a frame does not allocate.
+
+	var fp FrameParser
+	fp.Init()
+	if fp.Decode(data)==nil && fp.Has(layers.LayerTypeICMPv6) { ... }
+*/
+type FrameParser struct{
+	Eth eth.EthLayer2
+	IP ip.IPLayerPart
+	ICMPv4 layers.ICMPv4
+	ICMPv6 layers.ICMPv6
+	Payload gopacket.Payload
+	Decoded []gopacket.LayerType
+	
+	parser *gopacket.DecodingLayerParser
+}
+func (f *FrameParser) Init() *FrameParser {
+	f.parser = gopacket.NewDecodingLayerParser(layers.LayerTypeEthernet,&f.Eth,&f.IP,&f.ICMPv4,&f.ICMPv6,&f.Payload)
+	f.Decoded = make([]gopacket.LayerType,0,8)
+	return f
+}
+
+/* Decodes a frame. Layers, that are not supported, are ignored. */
+func (f *FrameParser) Decode(data []byte) error {
+	err := f.parser.DecodeLayers(data,&f.Decoded)
+	if _,ok := err.(gopacket.UnsupportedLayerType); ok { return nil }
+	return err
+}
+
+/* Reports, whether the last decoded frame contained a layer of type t. */
+func (f *FrameParser) Has(t gopacket.LayerType) bool {
+	for _,d := range f.Decoded {
+		if d==t { return true }
+	}
+	return false
+}
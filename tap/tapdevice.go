@@ -28,17 +28,23 @@ package tap
 import "github.com/songgao/water"
 import "github.com/google/gopacket"
 import "time"
+import "net"
 
 type Interface struct{
 	*water.Interface
 	MTU uint
+	
+	/* Reported as InterfaceIndex of received packets. */
+	Index int
 }
 
-// Opens a new TAP device.
+// Opens a new TAP device. The Index is the operating system's ifindex, if known.
 func New(ifName string) (i Interface, err error) {
 	var w *water.Interface
 	w,err = water.NewTAP(ifName)
-	i = Interface{w,1500}
+	i = Interface{Interface:w,MTU:1500}
+	if err!=nil { return }
+	if ifi,e2 := net.InterfaceByName(w.Name()); e2==nil { i.Index = ifi.Index }
 	return
 }
 
@@ -51,7 +57,7 @@ func (i Interface) ReadPacketData() (data []byte, ci gopacket.CaptureInfo, err e
 	ci.Timestamp = time.Now()
 	ci.CaptureLength = n
 	ci.Length = n
-	ci.InterfaceIndex = 0
+	ci.InterfaceIndex = i.Index
 	return
 }
 
@@ -62,7 +68,7 @@ func (i Interface) ReadPacketDataTo(data []byte) (ci gopacket.CaptureInfo, err e
 	ci.Timestamp = time.Now()
 	ci.CaptureLength = n
 	ci.Length = n
-	ci.InterfaceIndex = 0
+	ci.InterfaceIndex = i.Index
 	return
 }
 